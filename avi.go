@@ -0,0 +1,197 @@
+package selfies
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// aviWriter writes a Motion JPEG AVI, which is just each frame's JPEG one
+// after the other, with a header and an index that players understand.  The
+// header's counts aren't known until the end, so it's rewritten by Close.
+type aviWriter struct {
+	w             io.WriteSeeker
+	width, height int
+	fps           int
+	// the JPEG's offsets from the start of the movi list, and their sizes
+	offsets, sizes []uint32
+	moviSize       uint32
+	maxSize        uint32
+	last           []byte
+}
+
+// newAVIWriter starts an AVI of width x height frames at fps frames a second.
+func newAVIWriter(w io.Writer, width, height, fps int) (*aviWriter, error) {
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return nil, errors.New("avi: can't seek to write header")
+	}
+	a := &aviWriter{w: ws, width: width, height: height, fps: fps, moviSize: 4}
+	_, err := ws.Write(a.header())
+	return a, err
+}
+
+// header returns everything up to the first frame.
+func (a *aviWriter) header() []byte {
+	frames := uint32(len(a.sizes))
+	var b bytes.Buffer
+	le := func(vs ...interface{}) {
+		for _, v := range vs {
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+	}
+	b.WriteString("RIFF")
+	le(uint32(0)) // filled in below
+	b.WriteString("AVI LIST")
+	le(uint32(4 + 8 + 56 + 8 + 4 + 8 + 56 + 8 + 40))
+	b.WriteString("hdrlavih")
+	le(uint32(56),
+		uint32(time.Second/time.Microsecond)/uint32(a.fps), // microseconds per frame
+		a.maxSize*uint32(a.fps),                            // max bytes per second
+		uint32(0),                                          // padding granularity
+		uint32(0x10),                                       // has an index
+		frames,
+		uint32(0), // initial frames
+		uint32(1), // streams
+		a.maxSize,
+		uint32(a.width), uint32(a.height),
+		[4]uint32{})
+	b.WriteString("LIST")
+	le(uint32(4 + 8 + 56 + 8 + 40))
+	b.WriteString("strlstrh")
+	le(uint32(56))
+	b.WriteString("vidsMJPG")
+	le(uint32(0), // flags
+		uint16(0), uint16(0), // priority, language
+		uint32(0),     // initial frames
+		uint32(1),     // scale
+		uint32(a.fps), // rate, in frames per scale seconds
+		uint32(0),     // start
+		frames,        // length
+		a.maxSize,     // suggested buffer size
+		int32(-1),     // default quality
+		uint32(0),     // sample size varies
+		[4]int16{0, 0, int16(a.width), int16(a.height)})
+	b.WriteString("strf")
+	le(uint32(40),
+		uint32(40), int32(a.width), int32(a.height),
+		uint16(1), uint16(24), // planes, bits per pixel
+		[4]byte{'M', 'J', 'P', 'G'},
+		uint32(a.width*a.height*3),
+		int32(0), int32(0), uint32(0), uint32(0))
+	b.WriteString("LIST")
+	le(a.moviSize)
+	b.WriteString("movi")
+
+	out := b.Bytes()
+	// the RIFF holds the header, the frames and the index
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out))-8+a.moviSize-4+8+16*frames)
+	return out
+}
+
+// WriteFrame adds a JPEG as the next frame.
+func (a *aviWriter) WriteFrame(jpeg []byte) error {
+	size := uint32(len(jpeg))
+	var chunk bytes.Buffer
+	chunk.WriteString("00dc")
+	binary.Write(&chunk, binary.LittleEndian, size)
+	chunk.Write(jpeg)
+	if size%2 != 0 {
+		chunk.WriteByte(0)
+	}
+	if _, err := a.w.Write(chunk.Bytes()); err != nil {
+		return err
+	}
+	a.offsets = append(a.offsets, a.moviSize)
+	a.sizes = append(a.sizes, size)
+	a.moviSize += uint32(chunk.Len())
+	if size > a.maxSize {
+		a.maxSize = size
+	}
+	a.last = jpeg
+	return nil
+}
+
+// Repeat adds the last frame again, for when the camera fell behind.
+func (a *aviWriter) Repeat() error {
+	if a.last == nil {
+		return nil
+	}
+	return a.WriteFrame(a.last)
+}
+
+// Frames returns how many frames have been written.
+func (a *aviWriter) Frames() int {
+	return len(a.sizes)
+}
+
+// Close writes the index and the final header.  It doesn't close the file.
+func (a *aviWriter) Close() error {
+	var idx bytes.Buffer
+	idx.WriteString("idx1")
+	binary.Write(&idx, binary.LittleEndian, uint32(16*len(a.sizes)))
+	for i := range a.sizes {
+		idx.WriteString("00dc")
+		binary.Write(&idx, binary.LittleEndian, []uint32{0x10, a.offsets[i], a.sizes[i]}) // keyframe
+	}
+	if _, err := a.w.Write(idx.Bytes()); err != nil {
+		return err
+	}
+	if _, err := a.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := a.w.Write(a.header())
+	return err
+}
+
+// readAVI calls frame with each frame's JPEG in a Motion JPEG AVI written by
+// aviWriter, and the time between frames.  It stops early if frame returns
+// an error.
+func readAVI(r io.Reader, frame func(jpeg []byte, delay time.Duration) error) error {
+	var delay time.Duration
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return err
+	}
+	if string(riff[:4]) != "RIFF" || string(riff[8:]) != "AVI " {
+		return errors.New("not an avi")
+	}
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(r, head[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		id, size := string(head[:4]), binary.LittleEndian.Uint32(head[4:])
+		if id == "LIST" {
+			// the chunks in lists are read as though they weren't in one
+			var kind [4]byte
+			if _, err := io.ReadFull(r, kind[:]); err != nil {
+				return err
+			}
+			continue
+		}
+		if id == "idx1" {
+			return nil
+		}
+		data := make([]byte, size+size%2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		switch id {
+		case "avih":
+			if len(data) < 4 {
+				return fmt.Errorf("avi: short header")
+			}
+			delay = time.Duration(binary.LittleEndian.Uint32(data)) * time.Microsecond
+		case "00dc":
+			if err := frame(data[:size], delay); err != nil {
+				return err
+			}
+		}
+	}
+}
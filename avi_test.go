@@ -0,0 +1,170 @@
+package selfies
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tempFile creates a file in the test's temp directory, for writers that need to seek.
+func tempFile(t *testing.T) *os.File {
+	t.Helper()
+	fp, err := os.Create(filepath.Join(t.TempDir(), "test.avi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fp.Close() })
+	return fp
+}
+
+// readAll returns every frame readAVI finds in data, and the delay it gave them.
+func readAll(t *testing.T, data []byte) ([][]byte, time.Duration) {
+	t.Helper()
+	var frames [][]byte
+	var delay time.Duration
+	err := readAVI(bytes.NewReader(data), func(jpeg []byte, d time.Duration) error {
+		frames = append(frames, append([]byte(nil), jpeg...))
+		delay = d
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames, delay
+}
+
+func TestAVIRoundTrip(t *testing.T) {
+	fp := tempFile(t)
+	a, err := newAVIWriter(fp, 64, 48, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// odd sizes are padded to an even length
+	written := [][]byte{[]byte("first frame"), []byte("second"), []byte("third!!")}
+	for i, f := range written {
+		if err := a.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			if err := a.Repeat(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]byte{written[0], written[1], written[1], written[2]}
+	frames, delay := readAll(t, data)
+	if len(frames) != len(want) {
+		t.Fatalf("read %d frames, want %d", len(frames), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(frames[i], want[i]) {
+			t.Errorf("frame %d is %q, want %q", i, frames[i], want[i])
+		}
+	}
+	if delay != 125*time.Millisecond {
+		t.Errorf("frames are %v apart, want 125ms", delay)
+	}
+
+	le := binary.LittleEndian
+	if got := le.Uint32(data[4:]); int(got) != len(data)-8 {
+		t.Errorf("RIFF size is %d, want %d", got, len(data)-8)
+	}
+	// avih's frame count
+	if got := le.Uint32(data[48:]); got != 4 {
+		t.Errorf("the main header counts %d frames, want 4", got)
+	}
+	movi := bytes.Index(data, []byte("movi"))
+	idx := bytes.Index(data, []byte("idx1"))
+	if movi < 8 || idx < movi {
+		t.Fatalf("movi at %d, idx1 at %d", movi, idx)
+	}
+	if got := le.Uint32(data[movi-4:]); int(got) != idx-movi {
+		t.Errorf("movi size is %d, want %d", got, idx-movi)
+	}
+	if got := le.Uint32(data[idx+4:]); int(got) != 16*len(want) || idx+8+int(got) != len(data) {
+		t.Fatalf("idx1 size is %d, with %d bytes left", got, len(data)-idx-8)
+	}
+	for i := range want {
+		entry := data[idx+8+16*i:]
+		offset, size := le.Uint32(entry[8:]), le.Uint32(entry[12:])
+		if string(entry[:4]) != "00dc" || le.Uint32(entry[4:]) != 0x10 {
+			t.Errorf("index entry %d is %q", i, entry[:16])
+		}
+		chunk := data[movi+int(offset):]
+		if string(chunk[:4]) != "00dc" || le.Uint32(chunk[4:]) != size {
+			t.Errorf("index entry %d points at %q, not a frame of %d bytes", i, chunk[:8], size)
+			continue
+		}
+		if !bytes.Equal(chunk[8:8+size], want[i]) {
+			t.Errorf("index entry %d points at %q, want %q", i, chunk[8:8+size], want[i])
+		}
+	}
+}
+
+func TestReadAVIRejectsOtherFiles(t *testing.T) {
+	err := readAVI(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")), func([]byte, time.Duration) error { return nil })
+	if err == nil {
+		t.Error("read a WAV as an AVI")
+	}
+}
+
+func TestEncodeVideoKeepsTime(t *testing.T) {
+	const w, h, fps = 32, 24, 10
+	p := NewTestPattern(w, h).(*testPattern)
+	v := &videoRecording{frames: make(chan videoFrame, 8), format: FormatYUYV, width: w, height: h}
+	for _, f := range []struct {
+		n  int
+		at time.Duration
+	}{
+		{1, 0},
+		{2, 100 * time.Millisecond},
+		// too soon for the next frame, so dropped
+		{3, 150 * time.Millisecond},
+		// late, so the frame before is repeated in its place
+		{4, 320 * time.Millisecond},
+		{5, 400 * time.Millisecond},
+	} {
+		v.frames <- videoFrame{data: p.frame(f.n), at: f.at}
+	}
+	close(v.frames)
+
+	none, err := FindFilter("none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := tempFile(t)
+	first, err := encodeVideo(fp, v, w, h, fps, nil, none, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || first.Bounds().Dx() != w || first.Bounds().Dy() != h {
+		t.Fatalf("first frame is %v", first)
+	}
+	data, err := os.ReadFile(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, delay := readAll(t, data)
+	if delay != time.Second/fps {
+		t.Errorf("frames are %v apart, want %v", delay, time.Second/fps)
+	}
+	if len(frames) != 5 {
+		t.Fatalf("got %d frames, want 5", len(frames))
+	}
+	for i, want := range []bool{false, true, false, false} {
+		if got := bytes.Equal(frames[i], frames[i+1]); got != want {
+			t.Errorf("frame %d repeats the one before: %v, want %v", i+1, got, want)
+		}
+	}
+}
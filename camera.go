@@ -0,0 +1,246 @@
+package selfies
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/blackjack/webcam"
+)
+
+// PixelFormat is the layout of the frames returned by a FrameSource.
+type PixelFormat int
+
+const (
+	// FormatYUYV is packed YUV 4:2:2, two bytes per pixel (SDL's YUY2).
+	FormatYUYV PixelFormat = iota
+	// FormatMJPEG frames are each a complete JPEG image.
+	FormatMJPEG
+	// FormatNV12 is a plane of Y followed by a plane of interleaved Cb/Cr at half resolution.
+	FormatNV12
+)
+
+var formatNames = []string{"yuyv", "mjpeg", "nv12"}
+
+func (f PixelFormat) String() string {
+	if f >= 0 && int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("PixelFormat(%d)", int(f))
+}
+
+// ParsePixelFormat parses a format name as used in the config.
+func ParsePixelFormat(name string) (PixelFormat, error) {
+	for i, n := range formatNames {
+		if strings.EqualFold(n, name) {
+			return PixelFormat(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pixel format %q", name)
+}
+
+// the V4L2 fourcc codes for each PixelFormat
+var v4l2Formats = map[PixelFormat]webcam.PixelFormat{
+	FormatYUYV:  1448695129, // V4L2_PIX_FMT_YUYV
+	FormatMJPEG: 1196444237, // V4L2_PIX_FMT_MJPEG
+	FormatNV12:  842094158,  // V4L2_PIX_FMT_NV12
+}
+
+// above this many pixels, webcams generally can't do a decent frame rate
+// without compressing, so MJPEG is preferred
+const mjpegPixels = 640 * 480
+
+// FrameSource is anything that can feed frames to the booth: a real webcam,
+// a generated test pattern, or a directory of saved photos.
+type FrameSource interface {
+	// Format returns the pixel format and dimensions of the frames from ReadFrame.
+	Format() (PixelFormat, int, int)
+	Start() error
+	Stop() error
+	// ReadFrame returns the next frame, or an empty slice if no new frame is ready.
+	// The returned slice is only valid until the next call to ReadFrame.
+	ReadFrame() ([]byte, error)
+	Close() error
+}
+
+// OpenFrameSource opens the frame source described by cfg.Device, which is
+// either "test" for a generated test pattern, "dir:<path>" to replay the JPEGs
+// in a directory, "auto" for the first V4L2 device matching cfg.Name and
+// cfg.USBID, or the path to a V4L2 device.  V4L2 devices are reopened if they
+// stop producing frames, and report whether they're working with a
+// Connected() bool method.
+func OpenFrameSource(cfg CameraConfig) (FrameSource, error) {
+	switch {
+	case cfg.Device == "test":
+		return NewTestPattern(cfg.Width, cfg.Height), nil
+	case strings.HasPrefix(cfg.Device, "dir:"):
+		return NewReplaySource(strings.TrimPrefix(cfg.Device, "dir:"), cfg.Width, cfg.Height)
+	default:
+		return newReconnectingSource(func() (FrameSource, error) {
+			path := cfg.Device
+			if path == "auto" {
+				var err error
+				if path, err = FindVideoDevice(cfg.Name, cfg.USBID); err != nil {
+					return nil, err
+				}
+			}
+			return OpenV4L2(path, cfg)
+		}, cfg.Timeout.Duration)
+	}
+}
+
+type v4l2Source struct {
+	cam       *webcam.Webcam
+	name      string
+	path      string
+	cfg       CameraConfig
+	format    PixelFormat
+	width     int
+	height    int
+	streaming bool
+}
+
+// OpenV4L2 opens the V4L2 webcam at path and configures it to capture frames
+// of cfg's size.  cfg.Format is the name of the pixel format to use, or "auto"
+// to pick the best one the camera supports at that size.  The source is also a
+// StillCapturer, taking photos at cfg.StillWidth x cfg.StillHeight.
+func OpenV4L2(path string, cfg CameraConfig) (FrameSource, error) {
+	cam, err := webcam.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	v := &v4l2Source{cam: cam, path: path, cfg: cfg, width: cfg.Width, height: cfg.Height}
+	if v.format, err = negotiateFormat(cam, cfg.Width, cfg.Height, cfg.Format); err != nil {
+		cam.Close()
+		return nil, err
+	}
+	if err = cam.SetBufferCount(1); err != nil {
+		cam.Close()
+		return nil, err
+	}
+	if v.name, err = cam.GetName(); err != nil {
+		v.name = path
+	}
+	return v, nil
+}
+
+// cameraName returns the model of the camera behind src, if it knows, for photo metadata.
+func cameraName(src interface{}) string {
+	if n, ok := src.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// negotiateFormat sets the camera to the first format in order of preference
+// that it supports at width x height.  Drivers pick the nearest size they have
+// when asked for one they don't, so formats that come back at another size
+// are passed over.
+func negotiateFormat(cam *webcam.Webcam, width, height int, format string) (PixelFormat, error) {
+	var prefs []PixelFormat
+	switch {
+	case format != "" && format != "auto":
+		f, err := ParsePixelFormat(format)
+		if err != nil {
+			return 0, err
+		}
+		prefs = []PixelFormat{f}
+	case width*height > mjpegPixels:
+		prefs = []PixelFormat{FormatMJPEG, FormatYUYV, FormatNV12}
+	default:
+		prefs = []PixelFormat{FormatYUYV, FormatNV12, FormatMJPEG}
+	}
+
+	supported := cam.GetSupportedFormats()
+	var offered []string
+	for _, f := range prefs {
+		code := v4l2Formats[f]
+		if _, ok := supported[code]; !ok || !sizeSupported(cam.GetSupportedFrameSizes(code), width, height) {
+			continue
+		}
+		got, w, h, err := cam.SetImageFormat(code, uint32(width), uint32(height))
+		if err != nil || got != code {
+			continue
+		}
+		if int(w) != width || int(h) != height {
+			offered = append(offered, fmt.Sprintf("%v at %dx%d", f, w, h))
+			continue
+		}
+		return f, nil
+	}
+	var names []string
+	for _, name := range supported {
+		names = append(names, name)
+	}
+	if len(offered) > 0 {
+		return 0, fmt.Errorf("camera can't capture %v at %dx%d, it offered %s",
+			prefs, width, height, strings.Join(offered, ", "))
+	}
+	return 0, fmt.Errorf("camera can't capture %v at %dx%d, it supports: %s",
+		prefs, width, height, strings.Join(names, ", "))
+}
+
+// sizeSupported reports whether a camera with the given frame sizes can capture width x height.
+// Drivers that don't list their sizes are asked anyway, and negotiateFormat
+// checks the size they agree to.
+func sizeSupported(sizes []webcam.FrameSize, width, height int) bool {
+	if len(sizes) == 0 {
+		return true
+	}
+	w, h := uint32(width), uint32(height)
+	for _, fs := range sizes {
+		if fs.StepWidth == 0 || fs.StepHeight == 0 {
+			if fs.MinWidth == w && fs.MinHeight == h {
+				return true
+			}
+		} else if w >= fs.MinWidth && w <= fs.MaxWidth && h >= fs.MinHeight && h <= fs.MaxHeight &&
+			(w-fs.MinWidth)%fs.StepWidth == 0 && (h-fs.MinHeight)%fs.StepHeight == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the camera's card name, like "C922 Pro Stream Webcam".
+func (v *v4l2Source) Name() string {
+	return v.name
+}
+
+func (v *v4l2Source) Format() (PixelFormat, int, int) {
+	return v.format, v.width, v.height
+}
+
+func (v *v4l2Source) Start() error {
+	if v.cam == nil {
+		return errors.New("camera is closed")
+	}
+	v.streaming = true
+	return v.cam.StartStreaming()
+}
+
+func (v *v4l2Source) Stop() error {
+	if v.cam == nil {
+		return errors.New("camera is closed")
+	}
+	v.streaming = false
+	return v.cam.StopStreaming()
+}
+
+func (v *v4l2Source) ReadFrame() ([]byte, error) {
+	if v.cam == nil {
+		return nil, errors.New("camera is closed")
+	}
+	frame, err := v.cam.ReadFrame()
+	if err == syscall.EAGAIN {
+		return nil, nil
+	}
+	return frame, err
+}
+
+func (v *v4l2Source) Close() error {
+	if v.cam == nil {
+		return nil
+	}
+	return v.cam.Close()
+}
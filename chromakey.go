@@ -0,0 +1,234 @@
+package selfies
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	"github.com/redbo/selfies/convert"
+)
+
+// how far past the tolerance, as a fraction of it, the key fades out, so
+// the edges of people aren't jagged
+const keySoftness = 0.25
+
+// chromaKey replaces a backdrop of one color with a background picture.
+// Pixels are keyed on their chroma alone, so shadows on the backdrop are
+// keyed out along with the rest of it.  Every Cb, Cr pair is worked out up
+// front, which leaves a table lookup and a blend for each pixel.
+type chromaKey struct {
+	bg image.Image
+	// alpha is how much of the background shows through, out of 256, indexed by Cb<<8 | Cr
+	alpha [1 << 16]uint16
+	// despill is the foreground's Cb and Cr with the backdrop's color reflected onto it taken out
+	despill [1 << 16][2]uint8
+
+	// backgrounds scaled to each size they're needed at
+	mu    sync.Mutex
+	rgba  map[image.Point]*image.RGBA
+	yuyv  map[image.Point][]byte
+	ycbcr map[ycbcrShape]*image.YCbCr
+}
+
+type ycbcrShape struct {
+	rect  image.Rectangle
+	ratio image.YCbCrSubsampleRatio
+}
+
+// newChromaKey loads the background for cfg, or returns nil if keying is off.
+func newChromaKey(cfg ChromaKeyConfig) (*chromaKey, error) {
+	if cfg.Background == "" {
+		return nil, nil
+	}
+	bg, err := loadImage(cfg.Background)
+	if err != nil {
+		return nil, fmt.Errorf("chroma key background: %v", err)
+	}
+	c, err := parseColor(cfg.Color)
+	if err != nil {
+		return nil, err
+	}
+	_, kcb, kcr := color.RGBToYCbCr(c.R, c.G, c.B)
+	kx, kz := float64(kcb)-128, float64(kcr)-128
+	klen := math.Hypot(kx, kz)
+	if klen < 16 {
+		return nil, fmt.Errorf("chroma key color %s is too close to gray", cfg.Color)
+	}
+	ux, uz := kx/klen, kz/klen
+	inner := cfg.Tolerance * klen
+	outer := inner * (1 + keySoftness)
+
+	k := &chromaKey{
+		bg:    bg,
+		rgba:  make(map[image.Point]*image.RGBA),
+		yuyv:  make(map[image.Point][]byte),
+		ycbcr: make(map[ycbcrShape]*image.YCbCr),
+	}
+	for cb := 0; cb < 256; cb++ {
+		for cr := 0; cr < 256; cr++ {
+			i := cb<<8 | cr
+			switch d := math.Hypot(float64(cb)-float64(kcb), float64(cr)-float64(kcr)); {
+			case d <= inner:
+				k.alpha[i] = 256
+			case d < outer:
+				k.alpha[i] = uint16(256 * (outer - d) / (outer - inner))
+			}
+			// take out the part of the chroma that points towards the key color
+			x, z := float64(cb)-128, float64(cr)-128
+			if p := x*ux + z*uz; p > 0 {
+				x -= cfg.Spill * p * ux
+				z -= cfg.Spill * p * uz
+			}
+			k.despill[i] = [2]uint8{clampByte(128 + x), clampByte(128 + z)}
+		}
+	}
+	return k, nil
+}
+
+func clampByte(v float64) uint8 {
+	if v < 0 {
+		return 0
+	} else if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func blend(fg, bg uint8, a int) uint8 {
+	return uint8((int(fg)*(256-a) + int(bg)*a) >> 8)
+}
+
+// background returns the background scaled and cropped to cover w x h.
+func (k *chromaKey) background(w, h int) *image.RGBA {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.backgroundLocked(w, h)
+}
+
+func (k *chromaKey) backgroundLocked(w, h int) *image.RGBA {
+	size := image.Pt(w, h)
+	if bg, ok := k.rgba[size]; ok {
+		return bg
+	}
+	bg, err := convert.Image(k.bg, convert.CenterCrop(k.bg.Bounds(), w, h), w, h)
+	if err != nil {
+		// convert only fails on sizes it can't make, so this won't happen with a loaded image
+		bg = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	k.rgba[size] = bg
+	return bg
+}
+
+// yuyvBackground returns the background as a w x h YUYV frame.
+func (k *chromaKey) yuyvBackground(w, h int) []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	size := image.Pt(w, h)
+	if frame, ok := k.yuyv[size]; ok {
+		return frame
+	}
+	bg := k.backgroundLocked(w, h)
+	frame := make([]byte, 0, 2*w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x+1 < w; x += 2 {
+			p := bg.Pix[bg.PixOffset(x, y):]
+			y0, cb0, cr0 := color.RGBToYCbCr(p[0], p[1], p[2])
+			y1, cb1, cr1 := color.RGBToYCbCr(p[4], p[5], p[6])
+			frame = append(frame, y0, uint8((int(cb0)+int(cb1))/2), y1, uint8((int(cr0)+int(cr1))/2))
+		}
+	}
+	k.yuyv[size] = frame
+	return frame
+}
+
+// ycbcrBackground returns the background in the same shape as img.
+func (k *chromaKey) ycbcrBackground(img *image.YCbCr) *image.YCbCr {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	shape := ycbcrShape{img.Rect, img.SubsampleRatio}
+	if out, ok := k.ycbcr[shape]; ok {
+		return out
+	}
+	r := img.Rect
+	bg := k.backgroundLocked(r.Dx(), r.Dy())
+	out := image.NewYCbCr(r, img.SubsampleRatio)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := bg.Pix[bg.PixOffset(x-r.Min.X, y-r.Min.Y):]
+			yy, cb, cr := color.RGBToYCbCr(p[0], p[1], p[2])
+			out.Y[out.YOffset(x, y)] = yy
+			// subsampled chroma comes from the block's last pixel, which is close enough for a preview
+			c := out.COffset(x, y)
+			out.Cb[c], out.Cr[c] = cb, cr
+		}
+	}
+	k.ycbcr[shape] = out
+	return out
+}
+
+// YUYV writes a keyed copy of a width x height YUYV frame to dst, growing
+// it if needed, and returns it.
+func (k *chromaKey) YUYV(dst, frame []byte, width, height int) []byte {
+	bg := k.yuyvBackground(width, height)
+	if cap(dst) < len(frame) {
+		dst = make([]byte, len(frame))
+	}
+	dst = dst[:len(frame)]
+	n := len(frame)
+	if len(bg) < n {
+		n = len(bg)
+	}
+	for i := 0; i+3 < n; i += 4 {
+		c := int(frame[i+1])<<8 | int(frame[i+3])
+		a, fg := int(k.alpha[c]), k.despill[c]
+		dst[i] = blend(frame[i], bg[i], a)
+		dst[i+1] = blend(fg[0], bg[i+1], a)
+		dst[i+2] = blend(frame[i+2], bg[i+2], a)
+		dst[i+3] = blend(fg[1], bg[i+3], a)
+	}
+	return dst
+}
+
+// YCbCr keys img in place.
+func (k *chromaKey) YCbCr(img *image.YCbCr) {
+	bg := k.ycbcrBackground(img)
+	// chroma is shared between pixels, so every pixel has to be keyed on the original
+	cbs := append([]byte(nil), img.Cb...)
+	crs := append([]byte(nil), img.Cr...)
+	r := img.Rect
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ci, bci := img.COffset(x, y), bg.COffset(x, y)
+			c := int(cbs[ci])<<8 | int(crs[ci])
+			a, fg := int(k.alpha[c]), k.despill[c]
+			yi := img.YOffset(x, y)
+			img.Y[yi] = blend(img.Y[yi], bg.Y[bg.YOffset(x, y)], a)
+			img.Cb[ci] = blend(fg[0], bg.Cb[bci], a)
+			img.Cr[ci] = blend(fg[1], bg.Cr[bci], a)
+		}
+	}
+}
+
+// RGBA keys a photo in place.
+func (k *chromaKey) RGBA(img *image.RGBA) {
+	b := img.Bounds()
+	bg := k.background(b.Dx(), b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		bgRow := bg.Pix[bg.PixOffset(0, y-b.Min.Y):]
+		for i := 0; i < len(row); i += 4 {
+			yy, cb, cr := color.RGBToYCbCr(row[i], row[i+1], row[i+2])
+			c := int(cb)<<8 | int(cr)
+			a, fg := int(k.alpha[c]), k.despill[c]
+			if a == 0 && fg[0] == cb && fg[1] == cr {
+				continue
+			}
+			r, g, bl := color.YCbCrToRGB(yy, fg[0], fg[1])
+			row[i] = blend(r, bgRow[i], a)
+			row[i+1] = blend(g, bgRow[i+1], a)
+			row[i+2] = blend(bl, bgRow[i+2], a)
+		}
+	}
+}
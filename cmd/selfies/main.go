@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/redbo/selfies"
 	"github.com/veandco/go-sdl2/sdl"
@@ -10,7 +13,45 @@ import (
 )
 
 func main() {
-	os.Setenv("DISPLAY", ":0")
+	configFile := flag.String("config", "", "JSON config file")
+	camera := flag.String("camera", "", "V4L2 device, \"test\" for a test pattern, or \"dir:<path>\" to replay JPEGs")
+	controller := flag.String("controller", "", "arduino serial port, or \"fake\" to run without one")
+	printer := flag.String("printer", "", "printer type: obex, lp, folder or fake")
+	savePath := flag.String("savepath", "", "directory to save photos in")
+	headless := flag.Bool("headless", false, "draw offscreen instead of in a fullscreen window")
+	snapshots := flag.String("snapshots", "", "in headless mode, take one set of photos and save a screenshot of each stage here")
+	flag.Parse()
+
+	cfg := selfies.DefaultConfig()
+	if *configFile != "" {
+		var err error
+		if cfg, err = selfies.LoadConfig(*configFile); err != nil {
+			log.Fatalf("failed to load config: %v", err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "camera":
+			cfg.Camera.Device = *camera
+		case "controller":
+			cfg.Controller.Port = *controller
+		case "printer":
+			cfg.Printer.Type = *printer
+		case "savepath":
+			cfg.SavePath = *savePath
+		case "headless":
+			cfg.Display.Headless = *headless
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("bad config: %v", err)
+	}
+
+	if cfg.Display.Headless {
+		os.Setenv("SDL_VIDEODRIVER", "dummy")
+	} else {
+		os.Setenv("DISPLAY", ":0")
+	}
 
 	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		log.Fatalf("failed to initialize sdl: %v", err)
@@ -23,12 +64,61 @@ func main() {
 	}
 	defer ttf.Quit()
 
-	sdl.WarpMouseGlobal(900, 1600)
-	sdl.ShowCursor(sdl.DISABLE)
+	if !cfg.Display.Headless {
+		sdl.WarpMouseGlobal(900, 1600)
+		sdl.ShowCursor(sdl.DISABLE)
+	}
 
-	s, err := selfies.NewSelfies()
+	s, err := selfies.NewSelfies(cfg)
 	if err != nil {
 		log.Fatalf("failed to start selfies: %v", err)
 	}
+	defer s.Close()
+	if cfg.Display.Headless && *snapshots != "" {
+		if err := shootOnce(s, *snapshots); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	s.Run()
 }
+
+// shootOnce presses the shoot button and runs the booth until it's back to
+// idle, saving a screenshot to dir every time the booth changes state.
+func shootOnce(s *selfies.Selfies, dir string) error {
+	fake, ok := s.Controller().(*selfies.FakeController)
+	if !ok {
+		return fmt.Errorf("snapshots need -controller fake")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	snap := func(n int, state selfies.State) error {
+		return s.SaveSnapshot(filepath.Join(dir, fmt.Sprintf("%02d-%s.png", n, state)))
+	}
+	s.Step()
+	if err := snap(0, s.State()); err != nil {
+		return err
+	}
+	fake.Press(selfies.ButtonShoot)
+	last := s.State()
+	for n := 1; ; {
+		s.Step()
+		if state := s.State(); state != last {
+			if state == selfies.StateIdle {
+				// let the last photo and the print be saved, so they're in the snapshot
+				for s.Saving() {
+					s.Step()
+				}
+			}
+			if err := snap(n, state); err != nil {
+				return err
+			}
+			n++
+			last = state
+			if state == selfies.StateIdle {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package selfies
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config holds everything that differs between one booth and the next.
+type Config struct {
+	Display    DisplayConfig    `json:"display"`
+	Camera     CameraConfig     `json:"camera"`
+	Tethered   TetheredConfig   `json:"tethered"`
+	Controller ControllerConfig `json:"controller"`
+	Printer    PrinterConfig    `json:"printer"`
+	PrintQueue PrintQueueConfig `json:"print_queue"`
+	Timing     TimingConfig     `json:"timing"`
+	Strip      StripConfig      `json:"strip"`
+	Loop       LoopConfig       `json:"loop"`
+	Video      VideoConfig      `json:"video"`
+	Template   string           `json:"template"` // print template file, see LoadTemplate
+	SavePath   string           `json:"save_path"`
+	Filters    []string         `json:"filters"`  // the filters guests can pick from, see Filters; the first is used to start with
+	Overlays   []OverlayConfig  `json:"overlays"` // drawn over every photo, in order
+	ChromaKey  ChromaKeyConfig  `json:"chroma_key"`
+	Event      string           `json:"event"`       // recorded in each photo and session record
+	MinFreeMB  int              `json:"min_free_mb"` // photos aren't saved if it would leave less disk space than this
+}
+
+// OverlayConfig places a picture, usually a PNG with transparency, over the
+// photos.  X, Y, W and H are fractions of the photo's width and height; the
+// picture is scaled to fit in that box without stretching.  Leaving out the
+// size covers the whole photo, for frames and borders.
+type OverlayConfig struct {
+	File string  `json:"file"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	W    float64 `json:"w"`
+	H    float64 `json:"h"`
+}
+
+// ChromaKeyConfig replaces a green screen, or any backdrop of one color,
+// with the Background picture.  Keying is off if there's no background.
+// Tolerance is how close a color has to be to Color to be replaced, as a
+// fraction of Color's distance from gray; raise it if bits of the backdrop
+// show, lower it if bits of people disappear.  Spill, from 0 to 1, is how
+// much of the backdrop's color reflected onto people is taken out.
+type ChromaKeyConfig struct {
+	Background string  `json:"background"`
+	Color      string  `json:"color"` // "#rrggbb"
+	Tolerance  float64 `json:"tolerance"`
+	Spill      float64 `json:"spill"`
+}
+
+// DisplayConfig sets where the booth draws.  Normally that's a fullscreen
+// window; in headless mode it's an offscreen Width x Height image instead.
+// Layout is passed to ComputeLayout.
+type DisplayConfig struct {
+	Headless bool   `json:"headless"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Layout   string `json:"layout"`
+}
+
+type CameraConfig struct {
+	Device string `json:"device"` // see OpenFrameSource
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"` // "auto", "yuyv", "mjpeg" or "nv12"
+	// Name and USBID pick the camera when Device is "auto".  Either can be left out.
+	Name  string `json:"name"`   // part of the card name, e.g. "C922"
+	USBID string `json:"usb_id"` // vendor:product, e.g. "046d:085c"
+	// Timeout is how long the camera can go without a frame before it's reopened.
+	Timeout Duration `json:"timeout"`
+	// Stills is "full" to switch the camera to StillWidth x StillHeight to take
+	// each photo, or "preview" to use the preview frame.  A size of 0x0 means
+	// the largest the camera supports.
+	Stills      string `json:"stills"`
+	StillWidth  int    `json:"still_width"`
+	StillHeight int    `json:"still_height"`
+}
+
+// TetheredConfig sets up a camera that takes the photos instead of the webcam,
+// which is then only used for the preview.  Type is "" for none, or "gphoto2".
+type TetheredConfig struct {
+	Type    string   `json:"type"`
+	Port    string   `json:"port"` // gphoto2's --port, e.g. "usb:001,004", if there's more than one camera
+	Timeout Duration `json:"timeout"`
+}
+
+type ControllerConfig struct {
+	Port     string `json:"port"` // see OpenController
+	BaudRate uint   `json:"baud_rate"`
+}
+
+// PrinterConfig picks the printer backend.  Type is "obex" for a bluetooth
+// printer at Address/Channel, "lp" for the CUPS Queue with lp Options,
+// "folder" to drop files into Folder, or "fake" to not print at all.  obexftp
+// and lp are killed if they take longer than Timeout.
+type PrinterConfig struct {
+	Type    string   `json:"type"`
+	Address string   `json:"address"`
+	Channel int      `json:"channel"`
+	Queue   string   `json:"queue"`
+	Options []string `json:"options"`
+	Folder  string   `json:"folder"`
+	Timeout Duration `json:"timeout"`
+}
+
+// PrintQueueConfig limits how many prints each session gets and how hard
+// failed prints are retried.  A failed print waits RetryBackoff before its
+// first retry, doubling with each attempt after that.
+type PrintQueueConfig struct {
+	CopiesPerSession int      `json:"copies_per_session"`
+	MaxAttempts      int      `json:"max_attempts"`
+	RetryBackoff     Duration `json:"retry_backoff"`
+}
+
+// TimingConfig sets when things happen after the shoot button is pressed.
+// The countdown runs until Shutter, and the new photo is shown for Review
+// afterwards.  Loops record for Loop after the countdown, and videos for up
+// to Video.
+type TimingConfig struct {
+	Lights  Duration `json:"lights"`
+	Focus   Duration `json:"focus"`
+	Shutter Duration `json:"shutter"`
+	Review  Duration `json:"review"`
+	Loop    Duration `json:"loop"`
+	Video   Duration `json:"video"`
+}
+
+// VideoConfig sets the size of the video messages the video button records.
+// They're saved as Motion JPEG AVIs, cropped to the same shape as the photos.
+type VideoConfig struct {
+	FPS   int `json:"fps"`
+	Width int `json:"width"`
+}
+
+// LoopConfig sets the size of the animated GIFs the loop button records.
+// They're cut from the live view at FPS frames a second, and are Width
+// pixels wide and cropped to the same shape as the photos.
+type LoopConfig struct {
+	FPS   int `json:"fps"`
+	Width int `json:"width"`
+}
+
+// StripConfig sets how many photos are taken per button press and how they're
+// laid out on the composed strip.
+type StripConfig struct {
+	Shots   int `json:"shots"`
+	Columns int `json:"columns"`
+	Margin  int `json:"margin"` // pixels around and between photos
+}
+
+// Duration is a time.Duration that reads and writes JSON as a string like "3.5s".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings like \"1.5s\", got %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// DefaultConfig returns the configuration of the original wedding booth.
+func DefaultConfig() *Config {
+	return &Config{
+		Display:    DisplayConfig{Width: 900, Height: 1600, Layout: "auto"},
+		Camera:     CameraConfig{Device: "/dev/video0", Width: 1280, Height: 720, Format: "auto", Timeout: Duration{3 * time.Second}, Stills: "full"},
+		Tethered:   TetheredConfig{Timeout: Duration{30 * time.Second}},
+		Controller: ControllerConfig{Port: "/dev/ttyUSB0", BaudRate: 9600},
+		Printer:    PrinterConfig{Type: "obex", Address: "C4:30:18:19:C6:3D", Channel: 4, Timeout: Duration{2 * time.Minute}},
+		PrintQueue: PrintQueueConfig{CopiesPerSession: 2, MaxAttempts: 5, RetryBackoff: Duration{5 * time.Second}},
+		Timing: TimingConfig{
+			Lights:  Duration{3500 * time.Millisecond},
+			Focus:   Duration{4000 * time.Millisecond},
+			Shutter: Duration{4500 * time.Millisecond},
+			Review:  Duration{0},
+			Loop:    Duration{2 * time.Second},
+			Video:   Duration{30 * time.Second},
+		},
+		Video:     VideoConfig{FPS: 15, Width: 640},
+		Loop:      LoopConfig{FPS: 10, Width: 480},
+		Strip:     StripConfig{Shots: 4, Columns: 1, Margin: 30},
+		SavePath:  "~/selfies/snaps",
+		MinFreeMB: 200,
+		ChromaKey: ChromaKeyConfig{Color: "#00b140", Tolerance: 0.5, Spill: 0.5},
+		Filters:   []string{"none", "bw", "sepia", "high-contrast", "vintage", "mirror"},
+	}
+}
+
+// LoadConfig reads a JSON config file.  Anything the file leaves out keeps its
+// default value.  It isn't validated, so that command line settings can be
+// applied first.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			line := bytes.Count(data[:serr.Offset], []byte("\n")) + 1
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	// paths in the file are relative to it, unless they're in the home directory
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) && *path != "~" && !strings.HasPrefix(*path, "~/") {
+			*path = filepath.Join(filepath.Dir(filename), *path)
+		}
+	}
+	resolve(&cfg.SavePath)
+	resolve(&cfg.Template)
+	resolve(&cfg.ChromaKey.Background)
+	resolve(&cfg.Printer.Folder)
+	for i := range cfg.Overlays {
+		resolve(&cfg.Overlays[i].File)
+	}
+	return cfg, nil
+}
+
+// Validate checks the config for values the booth can't run with.
+func (c *Config) Validate() error {
+	var errs []string
+	bad := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	if c.Display.Headless && (c.Display.Width <= 0 || c.Display.Height <= 0) {
+		bad("display size must be positive in headless mode, got %dx%d", c.Display.Width, c.Display.Height)
+	}
+	switch c.Display.Layout {
+	case "", "auto", "portrait", "landscape":
+	default:
+		bad("display.layout must be auto, portrait or landscape, got %q", c.Display.Layout)
+	}
+	if c.Camera.Device == "" {
+		bad("camera.device is required")
+	}
+	if c.Camera.Width <= 0 || c.Camera.Height <= 0 {
+		bad("camera size must be positive, got %dx%d", c.Camera.Width, c.Camera.Height)
+	} else if c.Camera.Width%2 != 0 {
+		bad("camera.width must be even for YUYV capture, got %d", c.Camera.Width)
+	}
+	if c.Camera.Format != "" && c.Camera.Format != "auto" {
+		if _, err := ParsePixelFormat(c.Camera.Format); err != nil {
+			bad("camera.format: %v", err)
+		}
+	}
+	if c.Camera.USBID != "" {
+		var vendor, product uint16
+		if n, _ := fmt.Sscanf(c.Camera.USBID, "%4x:%4x", &vendor, &product); n != 2 || len(c.Camera.USBID) != 9 {
+			bad("camera.usb_id must look like 046d:085c, got %q", c.Camera.USBID)
+		}
+	}
+	switch c.Camera.Stills {
+	case "", "full", "preview":
+	default:
+		bad("camera.stills must be full or preview, got %q", c.Camera.Stills)
+	}
+	if c.Camera.StillWidth < 0 || c.Camera.StillHeight < 0 || (c.Camera.StillWidth == 0) != (c.Camera.StillHeight == 0) {
+		bad("camera still size must be positive, or 0x0 for the largest, got %dx%d", c.Camera.StillWidth, c.Camera.StillHeight)
+	} else if c.Camera.StillWidth%2 != 0 {
+		bad("camera.still_width must be even for YUYV capture, got %d", c.Camera.StillWidth)
+	}
+	if c.Camera.Timeout.Duration <= 0 {
+		bad("camera.timeout must be positive, got %v", c.Camera.Timeout)
+	}
+	switch c.Tethered.Type {
+	case "", "gphoto2":
+	default:
+		bad("tethered.type must be empty or gphoto2, got %q", c.Tethered.Type)
+	}
+	if c.Tethered.Type != "" && c.Tethered.Timeout.Duration <= 0 {
+		bad("tethered.timeout must be positive, got %v", c.Tethered.Timeout)
+	}
+	if c.Controller.Port == "" {
+		bad("controller.port is required")
+	}
+	if c.Controller.BaudRate == 0 {
+		bad("controller.baud_rate must be positive")
+	}
+	switch c.Printer.Type {
+	case "obex":
+		if _, err := net.ParseMAC(c.Printer.Address); err != nil {
+			bad("printer.address %q is not a bluetooth address", c.Printer.Address)
+		}
+		if c.Printer.Channel <= 0 {
+			bad("printer.channel must be positive, got %d", c.Printer.Channel)
+		}
+	case "lp", "fake":
+	case "folder":
+		if fi, err := os.Stat(c.Printer.Folder); err != nil || !fi.IsDir() {
+			bad("printer.folder %q is not a directory", c.Printer.Folder)
+		}
+	default:
+		bad("printer.type must be obex, lp, folder or fake, got %q", c.Printer.Type)
+	}
+	if c.Printer.Timeout.Duration <= 0 {
+		bad("printer.timeout must be positive, got %v", c.Printer.Timeout)
+	}
+	if c.PrintQueue.CopiesPerSession < 1 {
+		bad("print_queue.copies_per_session must be at least 1, got %d", c.PrintQueue.CopiesPerSession)
+	}
+	if c.PrintQueue.MaxAttempts < 1 {
+		bad("print_queue.max_attempts must be positive, got %d", c.PrintQueue.MaxAttempts)
+	}
+	if c.PrintQueue.RetryBackoff.Duration <= 0 {
+		bad("print_queue.retry_backoff must be positive, got %v", c.PrintQueue.RetryBackoff)
+	}
+	t := c.Timing
+	if t.Lights.Duration < 0 || t.Focus.Duration < 0 || t.Review.Duration < 0 {
+		bad("timings can't be negative")
+	}
+	if t.Shutter.Duration <= 0 {
+		bad("timing.shutter must be positive, got %v", t.Shutter)
+	}
+	if t.Loop.Duration <= 0 || t.Loop.Duration > 10*time.Second {
+		bad("timing.loop must be positive and at most 10s, got %v", t.Loop)
+	}
+	if t.Video.Duration <= 0 || t.Video.Duration > 5*time.Minute {
+		bad("timing.video must be positive and at most 5m, got %v", t.Video)
+	}
+	if c.Video.FPS < 1 || c.Video.FPS > 30 {
+		bad("video.fps must be between 1 and 30, got %d", c.Video.FPS)
+	}
+	if c.Video.Width < 30 || c.Video.Width > 1920 || c.Video.Width%2 != 0 {
+		bad("video.width must be even and between 30 and 1920, got %d", c.Video.Width)
+	}
+	if c.Loop.FPS < 1 || c.Loop.FPS > 25 {
+		bad("loop.fps must be between 1 and 25, got %d", c.Loop.FPS)
+	}
+	if c.Loop.Width < 30 || c.Loop.Width > 1920 {
+		bad("loop.width must be between 30 and 1920, got %d", c.Loop.Width)
+	}
+	if t.Lights.Duration > t.Shutter.Duration || t.Focus.Duration > t.Shutter.Duration {
+		bad("timing.lights (%v) and timing.focus (%v) must come before timing.shutter (%v)",
+			t.Lights, t.Focus, t.Shutter)
+	}
+	if c.Strip.Shots < 1 || c.Strip.Shots > 12 {
+		bad("strip.shots must be between 1 and 12, got %d", c.Strip.Shots)
+	}
+	if c.Strip.Columns < 1 {
+		bad("strip.columns must be positive, got %d", c.Strip.Columns)
+	}
+	if c.Strip.Margin < 0 {
+		bad("strip.margin can't be negative, got %d", c.Strip.Margin)
+	}
+	if len(c.Filters) == 0 {
+		bad("filters must list at least one filter")
+	}
+	for _, name := range c.Filters {
+		if _, err := FindFilter(name); err != nil {
+			bad("filters: %v", err)
+		}
+	}
+	for i, o := range c.Overlays {
+		if o.File == "" {
+			bad("overlay %d needs a file", i)
+		}
+		if o.X < 0 || o.Y < 0 || o.W < 0 || o.H < 0 || o.X+o.W > 1 || o.Y+o.H > 1 {
+			bad("overlay %d must be inside the photo, got %v,%v %vx%v", i, o.X, o.Y, o.W, o.H)
+		} else if (o.W == 0) != (o.H == 0) {
+			bad("overlay %d needs both a width and a height, or neither", i)
+		}
+	}
+	if c.ChromaKey.Background != "" {
+		if _, err := parseColor(c.ChromaKey.Color); err != nil {
+			bad("chroma_key.color: %v", err)
+		}
+		if c.ChromaKey.Tolerance <= 0 || c.ChromaKey.Tolerance > 1 {
+			bad("chroma_key.tolerance must be more than 0 and at most 1, got %v", c.ChromaKey.Tolerance)
+		}
+		if c.ChromaKey.Spill < 0 || c.ChromaKey.Spill > 1 {
+			bad("chroma_key.spill must be between 0 and 1, got %v", c.ChromaKey.Spill)
+		}
+	}
+	if c.MinFreeMB < 0 {
+		bad("min_free_mb can't be negative, got %d", c.MinFreeMB)
+	}
+	if c.SavePath == "" {
+		bad("save_path is required")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// expandHome replaces a leading "~/" in path with the current user's home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home dir: %v", err)
+	}
+	return filepath.Join(usr.HomeDir, strings.TrimPrefix(path, "~")), nil
+}
@@ -0,0 +1,212 @@
+package selfies
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+)
+
+// Relays wired to the controller board.
+const (
+	RelayFocus   = 0 // half-press on the camera's shutter release
+	RelayLights  = 1 // the flash
+	RelayShutter = 2 // full press on the camera's shutter release
+	RelayAux     = 3
+	numRelays    = 4
+)
+
+// Buttons are identified by the board pin they're wired to.  Pins 9 to 12
+// drive the relays, so later buttons are on the analog pins, which an Uno
+// numbers from 14 for A0.
+const (
+	ButtonShoot = 2
+	ButtonPrint = 3
+	// ButtonGallery opens and closes the gallery, where ButtonNext and
+	// ButtonPrev page through the photos, ButtonPrint prints the one shown
+	// and ButtonDelete pressed twice deletes it.
+	ButtonGallery = 4
+	ButtonNext    = 5
+	ButtonPrev    = 6
+	ButtonDelete  = 7
+	// ButtonFilter switches to the next filter from the config.
+	ButtonFilter = 8
+	// ButtonLoop records an animated loop instead of taking photos.
+	ButtonLoop = 14
+	// ButtonVideo starts recording a video message, and stops it again.
+	// ButtonReplay plays the last one back.
+	ButtonVideo  = 15
+	ButtonReplay = 16
+)
+
+// isButton reports whether a button can be wired to pin, so line noise and
+// pins that aren't in use are ignored.
+func isButton(pin int) bool {
+	switch pin {
+	case ButtonShoot, ButtonPrint, ButtonGallery, ButtonNext, ButtonPrev, ButtonDelete,
+		ButtonFilter, ButtonLoop, ButtonVideo, ButtonReplay:
+		return true
+	}
+	return false
+}
+
+// ButtonEvent is a single press of one of the booth's buttons.
+type ButtonEvent struct {
+	Button int
+	Time   time.Time
+}
+
+// Controller drives the booth's relays and reports its button presses.
+type Controller interface {
+	SetRelay(n int, on bool) error
+	ResetRelays() error
+	// Buttons returns a channel of button presses, which is closed if the controller goes away.
+	Buttons() <-chan ButtonEvent
+	Close() error
+}
+
+// OpenController opens the controller described by spec, which is either "fake"
+// for an in-memory controller or the serial port the arduino is attached to.
+func OpenController(spec string, baudRate uint) (Controller, error) {
+	if spec == "fake" {
+		return NewFakeController(), nil
+	}
+	return NewSerialController(spec, baudRate)
+}
+
+// serialController talks to an arduino running util/firmware.ino.  Relays
+// are switched with 'A'-'D' (on) and 'a'-'d' (off), 'R' turns them all off,
+// and each button press is reported as its pin number on a line by itself.
+type serialController struct {
+	port    io.ReadWriteCloser
+	buttons chan ButtonEvent
+	// done is closed by Close, so readButtons doesn't wait forever on a
+	// press nobody's reading
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+// NewSerialController opens the arduino on the given serial port.
+func NewSerialController(portName string, baudRate uint) (Controller, error) {
+	port, err := serial.Open(serial.OpenOptions{
+		PortName:        portName,
+		BaudRate:        baudRate,
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("serial.Open: %v", err)
+	}
+	return newSerialController(port, 5*time.Second), nil
+}
+
+// newSerialController starts reading button presses from port, ignoring any
+// that arrive during the settle period while the board resets.
+func newSerialController(port io.ReadWriteCloser, settle time.Duration) *serialController {
+	c := &serialController{port: port, buttons: make(chan ButtonEvent), done: make(chan struct{})}
+	c.ResetRelays()
+	go c.readButtons(time.Now().Add(settle))
+	return c
+}
+
+func (c *serialController) readButtons(ignoreUntil time.Time) {
+	defer close(c.buttons)
+	scanner := bufio.NewScanner(c.port)
+	for scanner.Scan() {
+		button, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil || !isButton(button) || time.Now().Before(ignoreUntil) {
+			continue
+		}
+		select {
+		case c.buttons <- ButtonEvent{Button: button, Time: time.Now()}:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *serialController) send(cmd byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.port.Write([]byte{cmd, '\r', '\n'})
+	return err
+}
+
+func (c *serialController) SetRelay(n int, on bool) error {
+	if n < 0 || n >= numRelays {
+		return fmt.Errorf("invalid relay %d", n)
+	}
+	if on {
+		return c.send('A' + byte(n))
+	}
+	return c.send('a' + byte(n))
+}
+
+func (c *serialController) ResetRelays() error {
+	return c.send('R')
+}
+
+func (c *serialController) Buttons() <-chan ButtonEvent {
+	return c.buttons
+}
+
+func (c *serialController) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.port.Close()
+}
+
+// FakeController is an in-memory Controller for tests and for running the booth
+// without the arduino attached.
+type FakeController struct {
+	mu      sync.Mutex
+	relays  [numRelays]bool
+	buttons chan ButtonEvent
+}
+
+func NewFakeController() *FakeController {
+	return &FakeController{buttons: make(chan ButtonEvent, 16)}
+}
+
+func (f *FakeController) SetRelay(n int, on bool) error {
+	if n < 0 || n >= numRelays {
+		return fmt.Errorf("invalid relay %d", n)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.relays[n] = on
+	return nil
+}
+
+func (f *FakeController) ResetRelays() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.relays = [numRelays]bool{}
+	return nil
+}
+
+func (f *FakeController) Buttons() <-chan ButtonEvent {
+	return f.buttons
+}
+
+func (f *FakeController) Close() error {
+	return nil
+}
+
+// Press simulates a press of the given button.
+func (f *FakeController) Press(button int) {
+	f.buttons <- ButtonEvent{Button: button, Time: time.Now()}
+}
+
+// Relay reports whether relay n is currently on.
+func (f *FakeController) Relay(n int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.relays[n]
+}
@@ -0,0 +1,146 @@
+package selfies
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePort is a serial port that reads from r and keeps what's written to it.
+type fakePort struct {
+	r       io.Reader
+	written bytes.Buffer
+	closed  bool
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	return p.written.Write(b)
+}
+
+func (p *fakePort) Close() error {
+	p.closed = true
+	return nil
+}
+
+// pressed returns every button reported by a controller reading input.
+func pressed(input string, settle time.Duration) []int {
+	c := newSerialController(&fakePort{r: strings.NewReader(input)}, settle)
+	var buttons []int
+	for ev := range c.Buttons() {
+		buttons = append(buttons, ev.Button)
+	}
+	return buttons
+}
+
+func TestSerialControllerButtons(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []int
+	}{
+		{"2\r\n", []int{ButtonShoot}},
+		{"2\r\n3\r\n2\r\n", []int{ButtonShoot, ButtonPrint, ButtonShoot}},
+		{" 8 \n14\r\n15\r\n16", []int{ButtonFilter, ButtonLoop, ButtonVideo, ButtonReplay}},
+		// noise from the board resetting, and pins nothing is wired to
+		{"\x00\xff2\r\njunk\r\n\r\n3\r\n", []int{ButtonPrint}},
+		{"0\r\n1\r\n9\r\n12\r\n13\r\n17\r\n-2\r\n99999999999999999999\r\n", nil},
+	}
+	for _, test := range tests {
+		if got := pressed(test.input, 0); !equalInts(got, test.want) {
+			t.Errorf("%q: got buttons %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestSerialControllerSettle(t *testing.T) {
+	if got := pressed("2\r\n3\r\n", time.Hour); got != nil {
+		t.Errorf("got buttons %v while the board was settling", got)
+	}
+}
+
+func TestSerialControllerRelays(t *testing.T) {
+	// nothing to read, so the controller never sends a button
+	r, w := io.Pipe()
+	defer w.Close()
+	port := &fakePort{r: r}
+	c := newSerialController(port, 0)
+	if got := port.written.String(); got != "R\r\n" {
+		t.Errorf("opening sent %q, want the relays reset", got)
+	}
+	port.written.Reset()
+
+	c.SetRelay(RelayFocus, true)
+	c.SetRelay(RelayLights, true)
+	c.SetRelay(RelayShutter, true)
+	c.SetRelay(RelayAux, true)
+	c.SetRelay(RelayFocus, false)
+	c.SetRelay(RelayAux, false)
+	c.ResetRelays()
+	if got, want := port.written.String(), "A\r\nB\r\nC\r\nD\r\na\r\nd\r\nR\r\n"; got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+
+	port.written.Reset()
+	for _, n := range []int{-1, numRelays} {
+		if err := c.SetRelay(n, true); err == nil {
+			t.Errorf("set relay %d", n)
+		}
+	}
+	if port.written.Len() != 0 {
+		t.Errorf("sent %q for relays that don't exist", port.written.String())
+	}
+
+	c.Close()
+	if !port.closed {
+		t.Error("the port wasn't closed")
+	}
+}
+
+// repeatReader reads s over and over, like a button held down.
+type repeatReader string
+
+func (r repeatReader) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		n += copy(b[n:], r)
+	}
+	return n, nil
+}
+
+func TestSerialControllerCloseWhileUnread(t *testing.T) {
+	c := newSerialController(&fakePort{r: repeatReader("2\r\n")}, 0)
+	// let readButtons block on a press nobody reads
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	stopped := make(chan struct{})
+	go func() {
+		for range c.Buttons() {
+		}
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readButtons kept sending after Close")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("closing twice: %v", err)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
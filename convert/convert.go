@@ -0,0 +1,287 @@
+// Package convert turns camera frames into RGBA images, cropping and scaling
+// them in the same pass over the pixels.
+//
+// Scaling down averages all the source pixels that fall in each destination
+// pixel, so thumbnails don't alias.  Scaling up repeats pixels.
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// CenterCrop returns the largest rect with the aspect ratio w:h that fits in
+// the middle of r.
+func CenterCrop(r image.Rectangle, w, h int) image.Rectangle {
+	cw, ch := r.Dx(), r.Dx()*h/w
+	if ch > r.Dy() {
+		cw, ch = r.Dy()*w/h, r.Dy()
+	}
+	min := r.Min.Add(image.Pt((r.Dx()-cw)/2, (r.Dy()-ch)/2))
+	return image.Rectangle{min, min.Add(image.Pt(cw, ch))}
+}
+
+// YUYV converts the crop rect of a packed YUV 4:2:2 frame to a w x h image.
+func YUYV(frame []byte, width, height int, crop image.Rectangle, w, h int) (*image.RGBA, error) {
+	if len(frame) < width*height*2 {
+		return nil, fmt.Errorf("short yuyv frame: %d bytes for %dx%d", len(frame), width, height)
+	}
+	p := planes{
+		y: frame, yStride: width * 2, yStep: 2,
+		cb: frame[1:], cr: frame[3:], cStride: width * 2, cStep: 4, cShiftX: 1,
+	}
+	return p.convert(image.Rect(0, 0, width, height), crop, w, h)
+}
+
+// NV12 converts the crop rect of an NV12 frame, a plane of Y followed by a
+// plane of interleaved Cb/Cr at half resolution, to a w x h image.
+func NV12(frame []byte, width, height int, crop image.Rectangle, w, h int) (*image.RGBA, error) {
+	if len(frame) < width*height*3/2 {
+		return nil, fmt.Errorf("short nv12 frame: %d bytes for %dx%d", len(frame), width, height)
+	}
+	uv := frame[width*height:]
+	p := planes{
+		y: frame, yStride: width, yStep: 1,
+		cb: uv, cr: uv[1:], cStride: width, cStep: 2, cShiftX: 1, cShiftY: 1,
+	}
+	return p.convert(image.Rect(0, 0, width, height), crop, w, h)
+}
+
+// YUYVToYCbCr unpacks a packed YUV 4:2:2 frame into a YCbCr image, without
+// converting it to RGB, for code that works on the planes.
+func YUYVToYCbCr(frame []byte, width, height int) (*image.YCbCr, error) {
+	if width%2 != 0 {
+		return nil, fmt.Errorf("yuyv frame has an odd width %d", width)
+	}
+	if len(frame) < width*height*2 {
+		return nil, fmt.Errorf("short yuyv frame: %d bytes for %dx%d", len(frame), width, height)
+	}
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio422)
+	for y := 0; y < height; y++ {
+		src := frame[y*width*2 : (y+1)*width*2]
+		yRow := img.Y[y*img.YStride : y*img.YStride+width]
+		cb := img.Cb[y*img.CStride : y*img.CStride+width/2]
+		cr := img.Cr[y*img.CStride : y*img.CStride+width/2]
+		for i := range cb {
+			s, yy := src[i*4:i*4+4:i*4+4], yRow[i*2:i*2+2:i*2+2]
+			yy[0], cb[i], yy[1], cr[i] = s[0], s[1], s[2], s[3]
+		}
+	}
+	return img, nil
+}
+
+// NV12ToYCbCr unpacks an NV12 frame into a 4:2:0 YCbCr image.
+func NV12ToYCbCr(frame []byte, width, height int) (*image.YCbCr, error) {
+	cw, ch := (width+1)/2, (height+1)/2
+	if len(frame) < width*height+cw*ch*2 {
+		return nil, fmt.Errorf("short nv12 frame: %d bytes for %dx%d", len(frame), width, height)
+	}
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	copy(img.Y, frame[:width*height])
+	uv := frame[width*height:]
+	for y := 0; y < ch; y++ {
+		src := uv[y*cw*2 : (y+1)*cw*2]
+		cb := img.Cb[y*img.CStride : y*img.CStride+cw]
+		cr := img.Cr[y*img.CStride : y*img.CStride+cw]
+		for i := range cb {
+			s := src[i*2 : i*2+2 : i*2+2]
+			cb[i], cr[i] = s[0], s[1]
+		}
+	}
+	return img, nil
+}
+
+// Image converts the crop rect of any image to a w x h image.  YCbCr images,
+// like decoded JPEGs, and RGBA images are handled directly; anything else is
+// drawn onto an RGBA image first.
+func Image(img image.Image, crop image.Rectangle, w, h int) (*image.RGBA, error) {
+	switch src := img.(type) {
+	case *image.YCbCr:
+		p := planes{
+			y: src.Y, yStride: src.YStride, yStep: 1,
+			cb: src.Cb, cr: src.Cr, cStride: src.CStride, cStep: 1,
+			minX: src.Rect.Min.X, minY: src.Rect.Min.Y,
+		}
+		switch src.SubsampleRatio {
+		case image.YCbCrSubsampleRatio444:
+		case image.YCbCrSubsampleRatio422:
+			p.cShiftX = 1
+		case image.YCbCrSubsampleRatio420:
+			p.cShiftX, p.cShiftY = 1, 1
+		case image.YCbCrSubsampleRatio440:
+			p.cShiftY = 1
+		case image.YCbCrSubsampleRatio411:
+			p.cShiftX = 2
+		case image.YCbCrSubsampleRatio410:
+			p.cShiftX, p.cShiftY = 2, 1
+		default:
+			return nil, fmt.Errorf("unknown subsample ratio %v", src.SubsampleRatio)
+		}
+		return p.convert(src.Rect, crop, w, h)
+	case *image.RGBA:
+		return scaleRGBA(src, crop, w, h)
+	}
+	rgba := image.NewRGBA(crop)
+	draw.Draw(rgba, crop, img, crop.Min, draw.Src)
+	return scaleRGBA(rgba, crop, w, h)
+}
+
+// planes describes where the Y, Cb and Cr samples of a YCbCr frame are, so
+// packed and planar layouts can share one conversion loop.  The chroma
+// sample for (x, y) is at cStride*(y>>cShiftY) + cStep*(x>>cShiftX) in both
+// cb and cr, relative to the frame's top left corner at (minX, minY).
+type planes struct {
+	y              []byte
+	yStride, yStep int
+
+	cb, cr           []byte
+	cStride, cStep   int
+	cShiftX, cShiftY uint
+
+	minX, minY int
+}
+
+func (p *planes) convert(bounds, crop image.Rectangle, w, h int) (*image.RGBA, error) {
+	xs, ys, err := spans(bounds, crop, w, h)
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	cMinX, cMinY := p.minX>>p.cShiftX, p.minY>>p.cShiftY
+	if w == crop.Dx() && h == crop.Dy() {
+		// no scaling, so no averaging either
+		for oy := 0; oy < h; oy++ {
+			y := crop.Min.Y + oy
+			c := (y>>p.cShiftY - cMinY) * p.cStride
+			p.row(dst.Pix[oy*dst.Stride:oy*dst.Stride+w*4], p.y[(y-p.minY)*p.yStride:], p.cb[c:], p.cr[c:], crop.Min.X)
+		}
+		return dst, nil
+	}
+	for oy := 0; oy < h; oy++ {
+		y0, y1 := ys[oy].lo, ys[oy].hi
+		out := dst.Pix[oy*dst.Stride:]
+		for ox := 0; ox < w; ox++ {
+			x0, x1 := xs[ox].lo, xs[ox].hi
+			var sy, scb, scr, n int
+			for y := y0; y < y1; y++ {
+				yRow := p.y[(y-p.minY)*p.yStride:]
+				cRow := (y>>p.cShiftY - cMinY) * p.cStride
+				for x := x0; x < x1; x++ {
+					sy += int(yRow[(x-p.minX)*p.yStep])
+					c := cRow + (x>>p.cShiftX-cMinX)*p.cStep
+					scb += int(p.cb[c])
+					scr += int(p.cr[c])
+				}
+				n += x1 - x0
+			}
+			r, g, b := color.YCbCrToRGB(uint8(sy/n), uint8(scb/n), uint8(scr/n))
+			out[ox*4], out[ox*4+1], out[ox*4+2], out[ox*4+3] = r, g, b, 255
+		}
+	}
+	return dst, nil
+}
+
+// row converts the pixels of one row starting at x0 into out, with the
+// same fixed point arithmetic as color.YCbCrToRGB.  Each chroma sample's
+// contribution is worked out once for all the pixels that share it.
+func (p *planes) row(out, yRow, cbRow, crRow []byte, x0 int) {
+	n := len(out) / 4
+	cMinX := p.minX >> p.cShiftX
+	yi, yStep := (x0-p.minX)*p.yStep, p.yStep
+	for ox := 0; ox < n; {
+		cx := (x0 + ox) >> p.cShiftX
+		c := (cx - cMinX) * p.cStep
+		cb, cr := int32(cbRow[c])-128, int32(crRow[c])-128
+		dr, dg, db := 91881*cr, -22554*cb-46802*cr, 116130*cb
+		end := (cx+1)<<p.cShiftX - x0
+		if end > n {
+			end = n
+		}
+		for ; ox < end; ox++ {
+			yy := int32(yRow[yi]) * 0x10101
+			yi += yStep
+			o := out[ox*4 : ox*4+4 : ox*4+4]
+			o[0], o[1], o[2], o[3] = clamp(yy+dr), clamp(yy+dg), clamp(yy+db), 255
+		}
+	}
+}
+
+// clampTable saturates the integer part of a channel value, offset by 512,
+// to a byte.  A lookup doesn't stall on the branches color.YCbCrToRGB takes.
+var clampTable = func() (t [1024]uint8) {
+	for i := range t {
+		if v := i - 512; v > 255 {
+			t[i] = 255
+		} else if v > 0 {
+			t[i] = uint8(v)
+		}
+	}
+	return t
+}()
+
+// clamp turns a 16.16 fixed point channel value into a byte, saturating.
+// Values from any Y, Cb and Cr are well inside the table.
+func clamp(v int32) uint8 {
+	return clampTable[(v>>16+512)&1023]
+}
+
+func scaleRGBA(src *image.RGBA, crop image.Rectangle, w, h int) (*image.RGBA, error) {
+	xs, ys, err := spans(src.Rect, crop, w, h)
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for oy := 0; oy < h; oy++ {
+		y0, y1 := ys[oy].lo, ys[oy].hi
+		out := dst.Pix[oy*dst.Stride:]
+		for ox := 0; ox < w; ox++ {
+			x0, x1 := xs[ox].lo, xs[ox].hi
+			var sr, sg, sb, sa, n int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[src.PixOffset(x0, y):src.PixOffset(x1, y)]
+				for i := 0; i < len(row); i += 4 {
+					sr += int(row[i])
+					sg += int(row[i+1])
+					sb += int(row[i+2])
+					sa += int(row[i+3])
+				}
+				n += x1 - x0
+			}
+			out[ox*4], out[ox*4+1], out[ox*4+2], out[ox*4+3] = uint8(sr/n), uint8(sg/n), uint8(sb/n), uint8(sa/n)
+		}
+	}
+	return dst, nil
+}
+
+// span is the source pixels lo <= i < hi that make up one destination pixel.
+type span struct {
+	lo, hi int
+}
+
+// spans splits crop into w columns and h rows of source pixels.
+func spans(bounds, crop image.Rectangle, w, h int) ([]span, []span, error) {
+	if w <= 0 || h <= 0 {
+		return nil, nil, fmt.Errorf("can't scale to %dx%d", w, h)
+	}
+	if crop.Empty() || !crop.In(bounds) {
+		return nil, nil, fmt.Errorf("crop %v is outside the %v frame", crop, bounds)
+	}
+	return split(crop.Min.X, crop.Dx(), w), split(crop.Min.Y, crop.Dy(), h), nil
+}
+
+// split divides length pixels starting at start into n even spans.  Each
+// covers at least one pixel, so when scaling up some pixels are used more
+// than once.
+func split(start, length, n int) []span {
+	s := make([]span, n)
+	for i := range s {
+		lo, hi := i*length/n, (i+1)*length/n
+		if hi <= lo {
+			hi = lo + 1
+		}
+		s[i] = span{start + lo, start + hi}
+	}
+	return s
+}
@@ -0,0 +1,421 @@
+package convert
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+// randomYCbCr returns an image of noise covering r, so every sample has to come
+// from the right place.
+func randomYCbCr(r image.Rectangle, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(r, ratio)
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(img.Y)
+	rnd.Read(img.Cb)
+	rnd.Read(img.Cr)
+	return img
+}
+
+// toYUYV packs a 4:2:2 image the way a webcam sends it.
+func toYUYV(img *image.YCbCr) []byte {
+	var frame []byte
+	b := img.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x += 2 {
+			c := img.COffset(x, y)
+			frame = append(frame, img.Y[img.YOffset(x, y)], img.Cb[c], img.Y[img.YOffset(x+1, y)], img.Cr[c])
+		}
+	}
+	return frame
+}
+
+// toNV12 packs a 4:2:0 image as a Y plane then interleaved Cb and Cr.
+func toNV12(img *image.YCbCr) []byte {
+	b := img.Rect
+	var frame []byte
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		frame = append(frame, img.Y[img.YOffset(b.Min.X, y):img.YOffset(b.Max.X, y)]...)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x += 2 {
+			c := img.COffset(x, y)
+			frame = append(frame, img.Cb[c], img.Cr[c])
+		}
+	}
+	return frame
+}
+
+// checkCrop checks that got is exactly the crop rect of img.
+func checkCrop(t *testing.T, got *image.RGBA, img *image.YCbCr, crop image.Rectangle) {
+	t.Helper()
+	if got.Bounds() != image.Rect(0, 0, crop.Dx(), crop.Dy()) {
+		t.Fatalf("got %v image, want %dx%d", got.Bounds(), crop.Dx(), crop.Dy())
+	}
+	for y := crop.Min.Y; y < crop.Max.Y; y++ {
+		for x := crop.Min.X; x < crop.Max.X; x++ {
+			c := img.YCbCrAt(x, y)
+			r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+			want := color.RGBA{r, g, b, 255}
+			if px := got.RGBAAt(x-crop.Min.X, y-crop.Min.Y); px != want {
+				t.Fatalf("pixel %d,%d of crop %v is %v, want %v", x, y, crop, px, want)
+			}
+		}
+	}
+}
+
+var crops = []image.Rectangle{
+	image.Rect(0, 0, 16, 12),
+	image.Rect(1, 0, 16, 12),
+	image.Rect(3, 1, 10, 6),
+	image.Rect(5, 7, 6, 8),
+	image.Rect(0, 11, 16, 12),
+}
+
+func TestYUYV(t *testing.T) {
+	img := randomYCbCr(image.Rect(0, 0, 16, 12), image.YCbCrSubsampleRatio422)
+	frame := toYUYV(img)
+	for _, crop := range crops {
+		got, err := YUYV(frame, 16, 12, crop, crop.Dx(), crop.Dy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkCrop(t, got, img, crop)
+	}
+}
+
+func TestNV12(t *testing.T) {
+	img := randomYCbCr(image.Rect(0, 0, 16, 12), image.YCbCrSubsampleRatio420)
+	frame := toNV12(img)
+	for _, crop := range crops {
+		got, err := NV12(frame, 16, 12, crop, crop.Dx(), crop.Dy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkCrop(t, got, img, crop)
+	}
+}
+
+func TestEveryColor(t *testing.T) {
+	// one 4:4:4 image per Cr value, with Y going across and Cb going down
+	img := image.NewYCbCr(image.Rect(0, 0, 256, 256), image.YCbCrSubsampleRatio444)
+	for cr := 0; cr < 256; cr++ {
+		for y := 0; y < 256; y++ {
+			for x := 0; x < 256; x++ {
+				i := y*256 + x
+				img.Y[i], img.Cb[i], img.Cr[i] = uint8(x), uint8(y), uint8(cr)
+			}
+		}
+		got, err := Image(img, img.Rect, 256, 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 256*256; i++ {
+			r, g, b := color.YCbCrToRGB(img.Y[i], img.Cb[i], img.Cr[i])
+			if p := got.Pix[i*4 : i*4+3]; p[0] != r || p[1] != g || p[2] != b {
+				t.Fatalf("Y %d Cb %d Cr %d is %v, want %v", img.Y[i], img.Cb[i], cr, p, []uint8{r, g, b})
+			}
+		}
+	}
+}
+
+func TestYUYVToYCbCr(t *testing.T) {
+	img := randomYCbCr(image.Rect(0, 0, 16, 12), image.YCbCrSubsampleRatio422)
+	got, err := YUYVToYCbCr(toYUYV(img), 16, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlanes(t, got, img)
+	if _, err := YUYVToYCbCr(make([]byte, 15*12*2), 15, 12); err == nil {
+		t.Error("unpacked a yuyv frame with an odd width")
+	}
+	if _, err := YUYVToYCbCr(make([]byte, 100), 16, 12); err == nil {
+		t.Error("unpacked a short yuyv frame")
+	}
+}
+
+func TestNV12ToYCbCr(t *testing.T) {
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 16, 12), image.Rect(0, 0, 16, 13)} {
+		img := randomYCbCr(r, image.YCbCrSubsampleRatio420)
+		got, err := NV12ToYCbCr(toNV12(img), r.Dx(), r.Dy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPlanes(t, got, img)
+	}
+	if _, err := NV12ToYCbCr(make([]byte, 16*12), 16, 12); err == nil {
+		t.Error("unpacked an nv12 frame with no chroma")
+	}
+}
+
+// checkPlanes checks that got has the same samples as want.
+func checkPlanes(t *testing.T, got, want *image.YCbCr) {
+	t.Helper()
+	if got.Rect != want.Rect || got.SubsampleRatio != want.SubsampleRatio {
+		t.Fatalf("got a %v %v image, want %v %v", got.SubsampleRatio, got.Rect, want.SubsampleRatio, want.Rect)
+	}
+	for y := want.Rect.Min.Y; y < want.Rect.Max.Y; y++ {
+		for x := want.Rect.Min.X; x < want.Rect.Max.X; x++ {
+			if g, w := got.YCbCrAt(x, y), want.YCbCrAt(x, y); g != w {
+				t.Fatalf("sample %d,%d is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestImageYCbCr(t *testing.T) {
+	ratios := []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	}
+	for _, ratio := range ratios {
+		// odd sizes, and a sub image that starts at odd coordinates, like a cropped JPEG
+		full := randomYCbCr(image.Rect(0, 0, 17, 13), ratio)
+		for _, img := range []*image.YCbCr{full, full.SubImage(image.Rect(3, 1, 16, 12)).(*image.YCbCr)} {
+			for _, crop := range []image.Rectangle{img.Rect, img.Rect.Inset(1), image.Rect(5, 5, 12, 6)} {
+				got, err := Image(img, crop, crop.Dx(), crop.Dy())
+				if err != nil {
+					t.Fatalf("%v: %v", ratio, err)
+				}
+				checkCrop(t, got, img, crop)
+			}
+		}
+	}
+}
+
+func TestImageOther(t *testing.T) {
+	// anything that isn't YCbCr or RGBA goes through draw
+	img := image.NewNRGBA(image.Rect(0, 0, 7, 5))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	crop := image.Rect(1, 1, 6, 4)
+	got, err := Image(img, crop, crop.Dx(), crop.Dy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := crop.Min.Y; y < crop.Max.Y; y++ {
+		for x := crop.Min.X; x < crop.Max.X; x++ {
+			want := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if px := got.RGBAAt(x-crop.Min.X, y-crop.Min.Y); px != want {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, px, want)
+			}
+		}
+	}
+}
+
+// blocks returns an image of bw x bh blocks of solid colors, and the colors.
+func blocks(cols, rows, bw, bh int, at image.Point) (*image.RGBA, [][]color.RGBA) {
+	img := image.NewRGBA(image.Rect(0, 0, at.X+cols*bw+3, at.Y+rows*bh+3))
+	colors := make([][]color.RGBA, rows)
+	rnd := rand.New(rand.NewSource(1))
+	for r := range colors {
+		colors[r] = make([]color.RGBA, cols)
+		for c := range colors[r] {
+			colors[r][c] = color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+			block := image.Rect(c*bw, r*bh, (c+1)*bw, (r+1)*bh).Add(at)
+			draw.Draw(img, block, image.NewUniform(colors[r][c]), image.Point{}, draw.Src)
+		}
+	}
+	return img, colors
+}
+
+func TestScaleDown(t *testing.T) {
+	// an odd number of blocks of odd sizes, away from the corner, each
+	// averaged into one pixel
+	at := image.Pt(3, 1)
+	img, colors := blocks(5, 3, 3, 5, at)
+	crop := image.Rect(at.X, at.Y, at.X+5*3, at.Y+3*5)
+	got, err := Image(img, crop, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range colors {
+		for c, want := range colors[r] {
+			if px := got.RGBAAt(c, r); px != want {
+				t.Errorf("pixel %d,%d is %v, want %v", c, r, px, want)
+			}
+		}
+	}
+}
+
+func TestScaleUp(t *testing.T) {
+	at := image.Pt(1, 2)
+	img, colors := blocks(3, 3, 1, 1, at)
+	got, err := Image(img, image.Rect(at.X, at.Y, at.X+3, at.Y+3), 9, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 9; x++ {
+			if px, want := got.RGBAAt(x, y), colors[y/2][x/3]; px != want {
+				t.Errorf("pixel %d,%d is %v, want %v", x, y, px, want)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	frame := make([]byte, 16*12*2)
+	if _, err := YUYV(frame[:100], 16, 12, image.Rect(0, 0, 16, 12), 16, 12); err == nil {
+		t.Error("short yuyv frame converted")
+	}
+	if _, err := NV12(frame[:100], 16, 12, image.Rect(0, 0, 16, 12), 16, 12); err == nil {
+		t.Error("short nv12 frame converted")
+	}
+	if _, err := YUYV(frame, 16, 12, image.Rect(8, 8, 20, 12), 4, 4); err == nil {
+		t.Error("crop outside the frame converted")
+	}
+	if _, err := YUYV(frame, 16, 12, image.Rect(0, 0, 16, 12), 0, 4); err == nil {
+		t.Error("converted to an empty image")
+	}
+}
+
+func TestCenterCrop(t *testing.T) {
+	tests := []struct {
+		r    image.Rectangle
+		w, h int
+		want image.Rectangle
+	}{
+		{image.Rect(0, 0, 1280, 720), 3, 2, image.Rect(100, 0, 1180, 720)},
+		{image.Rect(0, 0, 640, 480), 3, 2, image.Rect(0, 27, 640, 453)},
+		{image.Rect(10, 10, 40, 30), 3, 2, image.Rect(10, 10, 40, 30)},
+		{image.Rect(0, 0, 7, 7), 1, 1, image.Rect(0, 0, 7, 7)},
+	}
+	for _, test := range tests {
+		if got := CenterCrop(test.r, test.w, test.h); got != test.want {
+			t.Errorf("CenterCrop(%v, %d, %d) = %v, want %v", test.r, test.w, test.h, got, test.want)
+		}
+	}
+}
+
+// frameToImage is how photos were made from YUYV frames before this
+// package, kept to compare against: the frame is unpacked into a YCbCr
+// image, scaled with resize and drawn onto a 1080x720 crop.
+func frameToImage(frame []byte, width int, height int) image.Image {
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio422)
+	for i := 0; i < width*height; i++ {
+		img.Y[i] = frame[i*2]
+		if i%2 == 0 {
+			img.Cb[i/2] = frame[i*2+1]
+		} else {
+			img.Cr[i/2] = frame[i*2+1]
+		}
+	}
+	cropped := image.NewRGBA(image.Rect(0, 0, 1080, 720))
+	if height != 720 {
+		resized := resize.Resize(0, 720, img, resize.Bicubic)
+		draw.Draw(cropped, cropped.Bounds(), resized, image.Point{(resized.Bounds().Dx() - 1080) / 2, 0}, draw.Over)
+	} else {
+		draw.Draw(cropped, cropped.Bounds(), img, image.Point{(img.Bounds().Dx() - 1080) / 2, 0}, draw.Over)
+	}
+	return cropped
+}
+
+// startBenchmark starts timing b once its frame has been made.
+func startBenchmark(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+}
+
+func BenchmarkFrameToImage720p(b *testing.B) {
+	frame := toYUYV(randomYCbCr(image.Rect(0, 0, 1280, 720), image.YCbCrSubsampleRatio422))
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		frameToImage(frame, 1280, 720)
+	}
+}
+
+func BenchmarkYUYV720p(b *testing.B) {
+	frame := toYUYV(randomYCbCr(image.Rect(0, 0, 1280, 720), image.YCbCrSubsampleRatio422))
+	crop := CenterCrop(image.Rect(0, 0, 1280, 720), 3, 2)
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := YUYV(frame, 1280, 720, crop, 1080, 720); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// unpackYUYV is how frames were unpacked for previews and filters before
+// YUYVToYCbCr, kept to compare against.
+func unpackYUYV(frame []byte, width, height int) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio422)
+	for i := 0; i < width*height; i++ {
+		img.Y[i] = frame[i*2]
+		if i%2 == 0 {
+			img.Cb[i/2] = frame[i*2+1]
+		} else {
+			img.Cr[i/2] = frame[i*2+1]
+		}
+	}
+	return img
+}
+
+func BenchmarkUnpackYUYV720p(b *testing.B) {
+	frame := toYUYV(randomYCbCr(image.Rect(0, 0, 1280, 720), image.YCbCrSubsampleRatio422))
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		unpackYUYV(frame, 1280, 720)
+	}
+}
+
+func BenchmarkYUYVToYCbCr720p(b *testing.B) {
+	frame := toYUYV(randomYCbCr(image.Rect(0, 0, 1280, 720), image.YCbCrSubsampleRatio422))
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := YUYVToYCbCr(frame, 1280, 720); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFrameToImage1080p(b *testing.B) {
+	frame := toYUYV(randomYCbCr(image.Rect(0, 0, 1920, 1080), image.YCbCrSubsampleRatio422))
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		frameToImage(frame, 1920, 1080)
+	}
+}
+
+func BenchmarkYUYV1080p(b *testing.B) {
+	frame := toYUYV(randomYCbCr(image.Rect(0, 0, 1920, 1080), image.YCbCrSubsampleRatio422))
+	crop := CenterCrop(image.Rect(0, 0, 1920, 1080), 3, 2)
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := YUYV(frame, 1920, 1080, crop, 1080, 720); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNV12720p(b *testing.B) {
+	frame := toNV12(randomYCbCr(image.Rect(0, 0, 1280, 720), image.YCbCrSubsampleRatio420))
+	crop := CenterCrop(image.Rect(0, 0, 1280, 720), 3, 2)
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := NV12(frame, 1280, 720, crop, 1080, 720); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkThumbnail(b *testing.B) {
+	img := randomYCbCr(image.Rect(0, 0, 3264, 2448), image.YCbCrSubsampleRatio420)
+	crop := CenterCrop(img.Rect, 3, 2)
+	startBenchmark(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := Image(img, crop, 426, 284); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package selfies
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// VideoDevice is a V4L2 device as listed in sysfs.
+type VideoDevice struct {
+	Path  string // e.g. /dev/video0
+	Name  string // the card name, e.g. "C922 Pro Stream Webcam"
+	USBID string // vendor:product, e.g. "046d:085c", if it's a USB device
+	Index int    // 0 for a device's main capture node, higher for its metadata nodes
+}
+
+const sysVideo = "/sys/class/video4linux"
+
+// ListVideoDevices returns the video devices on the system, in order of their device paths.
+func ListVideoDevices() ([]VideoDevice, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysVideo, "video*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+	var devices []VideoDevice
+	for _, dir := range dirs {
+		d := VideoDevice{
+			Path: filepath.Join("/dev", filepath.Base(dir)),
+			Name: readSysfs(filepath.Join(dir, "name")),
+		}
+		fmt.Sscan(readSysfs(filepath.Join(dir, "index")), &d.Index)
+		// the device link points at the USB interface; the IDs are on its parent
+		if iface, err := filepath.EvalSymlinks(filepath.Join(dir, "device")); err == nil {
+			usbDir := filepath.Dir(iface)
+			vendor, product := readSysfs(filepath.Join(usbDir, "idVendor")), readSysfs(filepath.Join(usbDir, "idProduct"))
+			if vendor != "" && product != "" {
+				d.USBID = vendor + ":" + product
+			}
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+func readSysfs(filename string) string {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// FindVideoDevice returns the path of the first capture device whose name
+// contains name and whose USB ID is usbID.  Empty values match anything.
+func FindVideoDevice(name, usbID string) (string, error) {
+	devices, err := ListVideoDevices()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		if d.Index != 0 {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(d.Name), strings.ToLower(name)) {
+			continue
+		}
+		if usbID != "" && !strings.EqualFold(d.USBID, usbID) {
+			continue
+		}
+		return d.Path, nil
+	}
+	return "", fmt.Errorf("no camera matching name %q and usb id %q among %d video devices", name, usbID, len(devices))
+}
@@ -0,0 +1,80 @@
+package selfies
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// openDisplay sets up the renderer: a fullscreen window normally, or an
+// offscreen surface drawn by SDL's software renderer in headless mode.
+func (s *Selfies) openDisplay() error {
+	if s.cfg.Display.Headless {
+		s.screenWidth, s.screenHeight = int32(s.cfg.Display.Width), int32(s.cfg.Display.Height)
+		surface, err := sdl.CreateRGBSurfaceWithFormat(0, s.screenWidth, s.screenHeight, 32, sdl.PIXELFORMAT_ABGR8888)
+		if err != nil {
+			return fmt.Errorf("failed to create offscreen surface: %v", err)
+		}
+		s.cleanup(func() error {
+			surface.Free()
+			return nil
+		})
+		s.surface = surface
+		if s.renderer, err = sdl.CreateSoftwareRenderer(surface); err != nil {
+			return fmt.Errorf("error creating renderer: %v", err)
+		}
+		s.cleanup(s.renderer.Destroy)
+		return nil
+	}
+
+	window, err := sdl.CreateWindow("SELFIES", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
+		100, 100, sdl.WINDOW_SHOWN|sdl.WINDOW_FULLSCREEN_DESKTOP|sdl.WINDOW_BORDERLESS)
+	if err != nil {
+		return fmt.Errorf("failed to create window: %v", err)
+	}
+	s.cleanup(window.Destroy)
+	s.screenWidth, s.screenHeight = window.GetSize()
+
+	if s.renderer, err = sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED); err != nil {
+		return fmt.Errorf("error creating renderer: %v", err)
+	}
+	s.cleanup(s.renderer.Destroy)
+	return nil
+}
+
+// Snapshot returns a copy of what's currently on the screen.  It only works in headless mode.
+func (s *Selfies) Snapshot() (*image.RGBA, error) {
+	if s.surface == nil {
+		return nil, fmt.Errorf("snapshots need headless mode")
+	}
+	if err := s.surface.Lock(); err != nil {
+		return nil, err
+	}
+	defer s.surface.Unlock()
+	img := image.NewRGBA(image.Rect(0, 0, int(s.surface.W), int(s.surface.H)))
+	pix := s.surface.Pixels()
+	for y := 0; y < img.Rect.Dy(); y++ {
+		copy(img.Pix[y*img.Stride:(y+1)*img.Stride], pix[y*int(s.surface.Pitch):])
+	}
+	return img, nil
+}
+
+// SaveSnapshot writes what's on the screen to a PNG file.
+func (s *Selfies) SaveSnapshot(filename string) error {
+	img, err := s.Snapshot()
+	if err != nil {
+		return err
+	}
+	fp, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(fp, img); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,248 @@
+package selfies
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/redbo/selfies/convert"
+)
+
+// PhotoMeta is what's recorded in a saved photo's EXIF.
+type PhotoMeta struct {
+	Taken  time.Time
+	Camera string // the camera's model
+	Event  string // goes in the image description
+}
+
+// EXIF tags and types used by exifSegment
+const (
+	tagImageDescription = 0x010e
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+)
+
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func asciiEntry(tag uint16, s string) ifdEntry {
+	return ifdEntry{tag, typeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func shortEntry(tag uint16, v uint16) ifdEntry {
+	return ifdEntry{tag, typeShort, 1, binary.BigEndian.AppendUint16(nil, v)}
+}
+
+func longEntry(tag uint16, v uint32) ifdEntry {
+	return ifdEntry{tag, typeLong, 1, binary.BigEndian.AppendUint32(nil, v)}
+}
+
+// exifSegment returns a JPEG APP1 segment holding meta as EXIF.  Photos are
+// always saved upright, so the orientation is always 1.
+func exifSegment(meta *PhotoMeta) []byte {
+	stamp := meta.Taken.Format("2006:01:02 15:04:05")
+	ifd0 := []ifdEntry{}
+	if meta.Event != "" {
+		ifd0 = append(ifd0, asciiEntry(tagImageDescription, meta.Event))
+	}
+	if meta.Camera != "" {
+		ifd0 = append(ifd0, asciiEntry(tagModel, meta.Camera))
+	}
+	ifd0 = append(ifd0,
+		shortEntry(tagOrientation, 1),
+		asciiEntry(tagSoftware, "selfies"),
+		asciiEntry(tagDateTime, stamp),
+		longEntry(tagExifIFD, 0), // filled in below
+	)
+	exifIFD := []ifdEntry{asciiEntry(tagDateTimeOriginal, stamp)}
+
+	// the TIFF header, then both IFDs, then any values too big to go in them
+	ifdSize := func(entries []ifdEntry) uint32 { return uint32(2 + 12*len(entries) + 4) }
+	exifOffset := 8 + ifdSize(ifd0)
+	binary.BigEndian.PutUint32(ifd0[len(ifd0)-1].value, exifOffset)
+	dataOffset := exifOffset + ifdSize(exifIFD)
+
+	var tiff, data bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	for _, entries := range [][]ifdEntry{ifd0, exifIFD} {
+		binary.Write(&tiff, binary.BigEndian, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&tiff, binary.BigEndian, e.tag)
+			binary.Write(&tiff, binary.BigEndian, e.typ)
+			binary.Write(&tiff, binary.BigEndian, e.count)
+			if len(e.value) <= 4 {
+				// small values go in the entry itself, left justified
+				var v [4]byte
+				copy(v[:], e.value)
+				tiff.Write(v[:])
+				continue
+			}
+			binary.Write(&tiff, binary.BigEndian, dataOffset+uint32(data.Len()))
+			data.Write(e.value)
+			if data.Len()%2 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		// no next IFD
+		binary.Write(&tiff, binary.BigEndian, uint32(0))
+	}
+	tiff.Write(data.Bytes())
+
+	var seg bytes.Buffer
+	seg.Write([]byte{0xff, 0xe1})
+	binary.Write(&seg, binary.BigEndian, uint16(2+6+tiff.Len()))
+	seg.WriteString("Exif\x00\x00")
+	seg.Write(tiff.Bytes())
+	return seg.Bytes()
+}
+
+// exifWriter puts an APP1 segment straight after the start of image marker
+// of the JPEG written through it.
+type exifWriter struct {
+	w       io.Writer
+	segment []byte
+	started bool
+}
+
+func (e *exifWriter) Write(p []byte) (int, error) {
+	if e.started {
+		return e.w.Write(p)
+	}
+	if len(p) < 2 || p[0] != 0xff || p[1] != 0xd8 {
+		return 0, errors.New("exif: not the start of a jpeg")
+	}
+	e.started = true
+	if _, err := e.w.Write(p[:2]); err != nil {
+		return 0, err
+	}
+	if _, err := e.w.Write(e.segment); err != nil {
+		return 0, err
+	}
+	n, err := e.w.Write(p[2:])
+	return n + 2, err
+}
+
+// readEXIF returns the camera model and orientation recorded in a JPEG's
+// EXIF, or "" and 1 if it hasn't got them.
+func readEXIF(jpeg []byte) (string, int) {
+	model, orientation := "", 1
+	if len(jpeg) < 2 || jpeg[0] != 0xff || jpeg[1] != 0xd8 {
+		return model, orientation
+	}
+	// find the APP1 segment among the ones before the image data
+	var tiff []byte
+	for p := 2; p+4 <= len(jpeg) && jpeg[p] == 0xff; {
+		marker, size := jpeg[p+1], int(binary.BigEndian.Uint16(jpeg[p+2:]))
+		if marker == 0xda || size < 2 || p+2+size > len(jpeg) {
+			break
+		}
+		seg := jpeg[p+4 : p+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			tiff = seg[6:]
+			break
+		}
+		p += 2 + size
+	}
+	if len(tiff) < 8 {
+		return model, orientation
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return model, orientation
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return model, orientation
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(tiff) {
+			break
+		}
+		tag, typ, count := order.Uint16(tiff[e:]), order.Uint16(tiff[e+2:]), int(order.Uint32(tiff[e+4:]))
+		switch {
+		case tag == tagOrientation && typ == typeShort:
+			orientation = int(order.Uint16(tiff[e+8:]))
+		case tag == tagModel && typ == typeASCII:
+			value := tiff[e+8 : e+12]
+			if count > 4 {
+				off := int(order.Uint32(tiff[e+8:]))
+				if off < 0 || off+count > len(tiff) {
+					continue
+				}
+				value = tiff[off : off+count]
+			} else {
+				value = value[:count]
+			}
+			model = strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+		}
+	}
+	return model, orientation
+}
+
+// orient turns an image the way an EXIF orientation says it should be
+// shown, so it can be saved upright.
+func orient(img image.Image, orientation int) (image.Image, error) {
+	if orientation < 2 || orientation > 8 {
+		return img, nil
+	}
+	b := img.Bounds()
+	src, err := convert.Image(img, b, b.Dx(), b.Dy())
+	if err != nil {
+		return nil, err
+	}
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			// the pixel of src that ends up at x, y
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // mirrored, and on its side
+				sx, sy = y, x
+			case 6: // needs a quarter turn clockwise
+				sx, sy = y, h-1-x
+			case 7: // mirrored, and on its other side
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs a quarter turn anticlockwise
+				sx, sy = w-1-y, x
+			}
+			copy(row[4*x:4*x+4], src.Pix[sy*src.Stride+4*sx:])
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,130 @@
+package selfies
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+func TestReadEXIFWritten(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	meta := &PhotoMeta{Taken: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC), Camera: "Canon EOS R6", Event: "Wedding"}
+	if err := jpeg.Encode(&exifWriter{w: &buf, segment: exifSegment(meta)}, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if model, orientation := readEXIF(buf.Bytes()); model != meta.Camera || orientation != 1 {
+		t.Errorf("read model %q, orientation %d, want %q and 1", model, orientation, meta.Camera)
+	}
+}
+
+// littleEndianEXIF is a JPEG header with an APP0 segment, then EXIF in
+// Intel byte order, like most cameras write, with the given model and orientation.
+func littleEndianEXIF(model string, orientation uint16) []byte {
+	le := binary.LittleEndian
+	var tiff bytes.Buffer
+	tiff.WriteString("II")
+	binary.Write(&tiff, le, uint16(42))
+	binary.Write(&tiff, le, uint32(8))
+	binary.Write(&tiff, le, uint16(2))
+	binary.Write(&tiff, le, []uint16{tagModel, typeASCII})
+	binary.Write(&tiff, le, uint32(len(model)+1))
+	// short values go in the entry, longer ones after the IFD
+	if len(model) < 4 {
+		var v [4]byte
+		copy(v[:], model)
+		tiff.Write(v[:])
+	} else {
+		binary.Write(&tiff, le, uint32(8+2+2*12+4))
+	}
+	binary.Write(&tiff, le, []uint16{tagOrientation, typeShort})
+	binary.Write(&tiff, le, uint32(1))
+	binary.Write(&tiff, le, []uint16{orientation, 0})
+	binary.Write(&tiff, le, uint32(0))
+	if len(model) >= 4 {
+		tiff.WriteString(model + "\x00")
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0xff, 0xd8, 0xff, 0xe0, 0, 6})
+	out.WriteString("JFIF")
+	out.Write([]byte{0xff, 0xe1})
+	binary.Write(&out, binary.BigEndian, uint16(2+6+tiff.Len()))
+	out.WriteString("Exif\x00\x00")
+	out.Write(tiff.Bytes())
+	out.Write([]byte{0xff, 0xda})
+	return out.Bytes()
+}
+
+func TestReadEXIF(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		model       string
+		orientation int
+	}{
+		{"camera", littleEndianEXIF("NIKON D750 ", 6), "NIKON D750", 6},
+		{"short model", littleEndianEXIF("X1", 8), "X1", 8},
+		{"no exif", []byte{0xff, 0xd8, 0xff, 0xe0, 0, 2, 0xff, 0xda}, "", 1},
+		{"not a jpeg", []byte("GIF89a"), "", 1},
+		{"truncated", littleEndianEXIF("NIKON D750", 6)[:30], "", 1},
+	}
+	for _, test := range tests {
+		model, orientation := readEXIF(test.data)
+		if model != test.model || orientation != test.orientation {
+			t.Errorf("%s: read model %q, orientation %d, want %q and %d", test.name, model, orientation, test.model, test.orientation)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3x2 image whose pixels are a to f, going across then down
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.SetRGBA(i%3, i/3, color.RGBA{'a' + uint8(i), 0, 0, 255})
+	}
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+		{9, []string{"abc", "def"}},
+	}
+	for _, test := range tests {
+		img, err := orient(src, test.orientation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := img.Bounds()
+		var got []string
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			var row []byte
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				row = append(row, byte(r>>8))
+			}
+			got = append(got, string(row))
+		}
+		if len(got) != len(test.want) || len(got[0]) != len(test.want[0]) {
+			t.Errorf("orientation %d: got a %v image, want %dx%d", test.orientation, b, len(test.want[0]), len(test.want))
+			continue
+		}
+		for y := range got {
+			if got[y] != test.want[y] {
+				t.Errorf("orientation %d: got %v, want %v", test.orientation, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package selfies
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Filter is an effect guests can pick for their photos.  Filters work on the
+// luma and chroma of a frame, so the preview can be filtered before it's
+// converted to RGB, then tinted and flipped by the GPU while it's drawn, and
+// the saved photo gets the same treatment on the CPU.
+type Filter struct {
+	Name string
+	// curve maps each luma value to a new one
+	curve [256]uint8
+	// chroma scales the color difference, out of 256; 0 is black and white
+	chroma int
+	// tint multiplies each channel of the result, out of 255
+	tint color.RGBA
+	// Mirror flips the image left to right
+	Mirror bool
+}
+
+func linearCurve(f func(y float64) float64) (curve [256]uint8) {
+	for i := range curve {
+		v := f(float64(i))
+		if v < 0 {
+			v = 0
+		} else if v > 255 {
+			v = 255
+		}
+		curve[i] = uint8(v + 0.5)
+	}
+	return curve
+}
+
+var identityCurve = linearCurve(func(y float64) float64 { return y })
+
+var noTint = color.RGBA{255, 255, 255, 255}
+
+// Filters are all the filters, in the order a guest cycles through them.
+var Filters = []*Filter{
+	{Name: "none", curve: identityCurve, chroma: 256, tint: noTint},
+	{Name: "bw", curve: identityCurve, chroma: 0, tint: noTint},
+	{Name: "sepia", curve: identityCurve, chroma: 0, tint: color.RGBA{255, 222, 170, 255}},
+	{Name: "high-contrast", curve: linearCurve(func(y float64) float64 { return (y-128)*1.6 + 128 }), chroma: 320, tint: noTint},
+	{Name: "vintage", curve: linearCurve(func(y float64) float64 { return 32 + y*0.75 }), chroma: 150, tint: color.RGBA{255, 235, 200, 255}},
+	{Name: "mirror", curve: identityCurve, chroma: 256, tint: noTint, Mirror: true},
+}
+
+// FindFilter returns the filter called name.
+func FindFilter(name string) (*Filter, error) {
+	for _, f := range Filters {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown filter %q", name)
+}
+
+// changesPixels reports whether the filter does anything but flip.
+func (f *Filter) changesPixels() bool {
+	return f.chroma != 256 || f.curve != identityCurve
+}
+
+func (f *Filter) luma(y uint8) uint8 {
+	return f.curve[y]
+}
+
+func (f *Filter) chromaOf(c uint8) uint8 {
+	v := 128 + (int(c)-128)*f.chroma/256
+	if v < 0 {
+		return 0
+	} else if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// YUYV writes a filtered copy of a YUYV frame to dst, growing it if needed,
+// and returns it.  The camera's frame can't be changed in place, since it's
+// still needed unfiltered.
+func (f *Filter) YUYV(dst, frame []byte) []byte {
+	if cap(dst) < len(frame) {
+		dst = make([]byte, len(frame))
+	}
+	dst = dst[:len(frame)]
+	for i := 0; i+1 < len(frame); i += 2 {
+		dst[i] = f.luma(frame[i])
+		dst[i+1] = f.chromaOf(frame[i+1])
+	}
+	return dst
+}
+
+// YCbCr filters the planes of img in place.
+func (f *Filter) YCbCr(img *image.YCbCr) {
+	if !f.changesPixels() {
+		return
+	}
+	for i, y := range img.Y {
+		img.Y[i] = f.luma(y)
+	}
+	for i := range img.Cb {
+		img.Cb[i], img.Cr[i] = f.chromaOf(img.Cb[i]), f.chromaOf(img.Cr[i])
+	}
+}
+
+// RGBA filters a photo in place, the same as the preview looks.
+func (f *Filter) RGBA(img *image.RGBA) {
+	b := img.Bounds()
+	if f.changesPixels() || f.tint != noTint {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				yy, cb, cr := color.RGBToYCbCr(row[i], row[i+1], row[i+2])
+				r, g, bl := color.YCbCrToRGB(f.luma(yy), f.chromaOf(cb), f.chromaOf(cr))
+				row[i] = uint8(int(r) * int(f.tint.R) / 255)
+				row[i+1] = uint8(int(g) * int(f.tint.G) / 255)
+				row[i+2] = uint8(int(bl) * int(f.tint.B) / 255)
+			}
+		}
+	}
+	if f.Mirror {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+			for l, r := 0, len(row)-4; l < r; l, r = l+4, r-4 {
+				for k := 0; k < 4; k++ {
+					row[l+k], row[r+k] = row[r+k], row[l+k]
+				}
+			}
+		}
+	}
+}
+
+// drawPreview draws src of the preview texture to dst, tinted and flipped as the filter says.
+func (f *Filter) drawPreview(renderer *sdl.Renderer, tex *sdl.Texture, src, dst *sdl.Rect) {
+	tex.SetColorMod(f.tint.R, f.tint.G, f.tint.B)
+	flip := sdl.FLIP_NONE
+	if f.Mirror {
+		flip = sdl.FLIP_HORIZONTAL
+	}
+	renderer.CopyEx(tex, src, dst, 0, nil, flip)
+}
@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/veandco/go-sdl2/sdl"
 	"github.com/veandco/go-sdl2/ttf"
@@ -3106,27 +3107,65 @@ var _ralewayBlackTtf = []byte(
 		"\x10\x18\xbc\x8e\xd7\x15\xe6\x3f\xe1\x7e\x21\x43\x7c\xe6\x75\xed\x7d\x3c\x7f\x5b\x7e\xf3\xcb" +
 		"\xf8\x6f\x7e\x39\x48\x8f\xf1\xff\x3f\x00\x00\xff\xff\xa2\x2d\x4e\x57\x70\xc1\x02\x00")
 
+// ttfMu is held for every call into SDL_ttf, which isn't thread safe, since
+// print templates are rendered on the pipeline while the render loop draws
+// text.
+var ttfMu sync.Mutex
+
+// renderBlended renders text in font, holding ttfMu.
+func renderBlended(font *ttf.Font, text string, color sdl.Color) (*sdl.Surface, error) {
+	ttfMu.Lock()
+	defer ttfMu.Unlock()
+	return font.RenderUTF8Blended(text, color)
+}
+
+// closeFont closes a font from makeFont, holding ttfMu.
+func closeFont(font *ttf.Font) {
+	ttfMu.Lock()
+	defer ttfMu.Unlock()
+	font.Close()
+}
+
+// fontData is the uncompressed font.  Fonts read it in place for as long as
+// they're open, so it's kept for the life of the program.
+var (
+	fontOnce sync.Once
+	fontData []byte
+	fontErr  error
+)
+
 func makeFont(size int) (*ttf.Font, error) {
-	gz, err := gzip.NewReader(bytes.NewBuffer(_ralewayBlackTtf))
-	if err != nil {
-		return nil, fmt.Errorf("uncompressing font")
-	}
+	fontOnce.Do(func() {
+		gz, err := gzip.NewReader(bytes.NewBuffer(_ralewayBlackTtf))
+		if err != nil {
+			fontErr = fmt.Errorf("uncompressing font")
+			return
+		}
 
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, gz); err != nil {
-		return nil, fmt.Errorf("copying font to buffer")
-	}
-	clErr := gz.Close()
-	if clErr != nil {
-		return nil, fmt.Errorf("closing font gz")
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, gz); err != nil {
+			fontErr = fmt.Errorf("copying font to buffer")
+			return
+		}
+		clErr := gz.Close()
+		if clErr != nil {
+			fontErr = fmt.Errorf("closing font gz")
+			return
+		}
+		fontData = buf.Bytes()
+	})
+	if fontErr != nil {
+		return nil, fontErr
 	}
 
-	rwops, err := sdl.RWFromMem(buf.Bytes())
+	rwops, err := sdl.RWFromMem(fontData)
 	if err != nil {
 		return nil, fmt.Errorf("uncompressing font")
 	}
-	defer rwops.Close()
+	// the font frees rwops when it's closed, or here if it can't be opened
+	ttfMu.Lock()
 	font, err := ttf.OpenFontRW(rwops, 1, size)
+	ttfMu.Unlock()
 	if err != nil {
 		return nil, fmt.Errorf("opening font")
 	}
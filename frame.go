@@ -0,0 +1,123 @@
+package selfies
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/redbo/selfies/convert"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// previewTextureFormat returns the SDL texture format frames in format are uploaded as.
+func previewTextureFormat(format PixelFormat) uint32 {
+	if format == FormatYUYV {
+		return sdl.PIXELFORMAT_YUY2
+	}
+	return sdl.PIXELFORMAT_IYUV
+}
+
+// updatePreview uploads a frame to a texture created with previewTextureFormat,
+// keying it with key if that's not nil and applying the luma and chroma parts
+// of filter.  YUYV frames are changed in scratch, which is grown as needed.
+func updatePreview(tex *sdl.Texture, format PixelFormat, frame []byte, width, height int, key *chromaKey, filter *Filter, scratch *[]byte) error {
+	rect := &sdl.Rect{X: 0, Y: 0, W: int32(width), H: int32(height)}
+	switch format {
+	case FormatYUYV:
+		if key != nil {
+			*scratch = key.YUYV(*scratch, frame, width, height)
+			frame = *scratch
+		}
+		if filter.changesPixels() {
+			*scratch = filter.YUYV(*scratch, frame)
+			frame = *scratch
+		}
+		return tex.Update(rect, frame, 2*width)
+	case FormatNV12:
+		img, err := decodeFrame(frame, format, width, height)
+		if err != nil {
+			return err
+		}
+		ycc := img.(*image.YCbCr)
+		if key != nil {
+			key.YCbCr(ycc)
+		}
+		filter.YCbCr(ycc)
+		return tex.UpdateYUV(rect, ycc.Y, ycc.YStride, ycc.Cb, ycc.CStride, ycc.Cr, ycc.CStride)
+	case FormatMJPEG:
+		img, err := decodeFrame(frame, format, width, height)
+		if err != nil {
+			return err
+		}
+		ycc, ok := img.(*image.YCbCr)
+		if !ok {
+			return fmt.Errorf("unexpected %T in mjpeg stream", img)
+		}
+		if key != nil {
+			key.YCbCr(ycc)
+		}
+		filter.YCbCr(ycc)
+		switch ycc.SubsampleRatio {
+		case image.YCbCrSubsampleRatio420:
+			return tex.UpdateYUV(rect, ycc.Y, ycc.YStride, ycc.Cb, ycc.CStride, ycc.Cr, ycc.CStride)
+		case image.YCbCrSubsampleRatio422:
+			// same as 4:2:0 if every other chroma row is skipped
+			return tex.UpdateYUV(rect, ycc.Y, ycc.YStride, ycc.Cb, 2*ycc.CStride, ycc.Cr, 2*ycc.CStride)
+		}
+		ycc = to420(ycc)
+		return tex.UpdateYUV(rect, ycc.Y, ycc.YStride, ycc.Cb, ycc.CStride, ycc.Cr, ycc.CStride)
+	}
+	return fmt.Errorf("can't preview %v frames", format)
+}
+
+// decodeFrame turns a raw frame from a FrameSource into an image.
+func decodeFrame(frame []byte, format PixelFormat, width, height int) (image.Image, error) {
+	switch format {
+	case FormatYUYV, FormatNV12:
+		unpack := convert.YUYVToYCbCr
+		if format == FormatNV12 {
+			unpack = convert.NV12ToYCbCr
+		}
+		img, err := unpack(frame, width, height)
+		if err != nil {
+			// not a nil *image.YCbCr in an image.Image
+			return nil, err
+		}
+		return img, nil
+	case FormatMJPEG:
+		return jpeg.Decode(bytes.NewReader(frame))
+	}
+	return nil, fmt.Errorf("can't decode %v frames", format)
+}
+
+// convertFrame converts the crop rect of a raw frame from a FrameSource to a w x h image.
+func convertFrame(frame []byte, format PixelFormat, width, height int, crop image.Rectangle, w, h int) (*image.RGBA, error) {
+	switch format {
+	case FormatYUYV:
+		return convert.YUYV(frame, width, height, crop, w, h)
+	case FormatNV12:
+		return convert.NV12(frame, width, height, crop, w, h)
+	}
+	img, err := decodeFrame(frame, format, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return convert.Image(img, crop, w, h)
+}
+
+// to420 resamples the chroma of img to 4:2:0.
+func to420(img *image.YCbCr) *image.YCbCr {
+	b := img.Rect
+	out := image.NewYCbCr(b, image.YCbCrSubsampleRatio420)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		copy(out.Y[out.YOffset(b.Min.X, y):out.YOffset(b.Max.X-1, y)+1], img.Y[img.YOffset(b.Min.X, y):img.YOffset(b.Max.X-1, y)+1])
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x += 2 {
+			i, o := img.COffset(x, y), out.COffset(x, y)
+			out.Cb[o], out.Cr[o] = img.Cb[i], img.Cr[i]
+		}
+	}
+	return out
+}
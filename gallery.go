@@ -0,0 +1,322 @@
+package selfies
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redbo/selfies/convert"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// deleting a photo in the gallery takes two presses of the delete button within this long
+const deleteConfirmTime = 3 * time.Second
+
+// the gallery closes by itself if no buttons are pressed for this long
+const galleryTimeout = time.Minute
+
+// photos deleted from the gallery are moved into this directory under the
+// save path rather than removed, in case a guest deletes the wrong one
+const deletedDir = "deleted"
+
+// galleryItem is one photo or print that can be browsed in the gallery.
+type galleryItem struct {
+	session string
+	file    string // relative to the save path
+	print   bool
+}
+
+// gallery is the state of the photo browser, while it's open.  It shows the
+// selected photo large where the live view normally is, and the ones after
+// it in the thumbnail grid.
+type gallery struct {
+	items []galleryItem // newest first
+	index int
+
+	large  *sdl.Texture
+	thumbs []*sdl.Texture
+	label  *sdl.Texture
+	// bumped whenever the selection changes, so images loaded for an old one are thrown away
+	gen    int
+	loaded bool
+
+	lastPress   time.Time
+	deleteArmed time.Time
+}
+
+// galleryItems lists the photos and prints from every session, newest first.
+func galleryItems(records []*SessionRecord) []galleryItem {
+	var items []galleryItem
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		if rec.Print != "" {
+			items = append(items, galleryItem{session: rec.ID, file: rec.Print, print: true})
+		}
+		for j := len(rec.Shots) - 1; j >= 0; j-- {
+			items = append(items, galleryItem{session: rec.ID, file: rec.Shots[j].File})
+		}
+	}
+	return items
+}
+
+// openGallery starts browsing the saved photos.
+func (s *Selfies) openGallery() {
+	records, err := LoadSessionRecords(s.storage)
+	if err != nil {
+		s.reportError(fmt.Errorf("can't open gallery: %v", err))
+		return
+	}
+	g := &gallery{items: galleryItems(records), lastPress: time.Now()}
+	if len(g.items) == 0 {
+		s.reportError(fmt.Errorf("no photos yet"))
+		return
+	}
+	l := &s.layout
+	if g.large, err = s.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, l.Review.W, l.Review.H); err != nil {
+		s.reportError(fmt.Errorf("can't open gallery: %v", err))
+		return
+	}
+	for range l.Thumbs {
+		tex, err := s.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, l.ThumbWidth, l.ThumbHeight)
+		if err != nil {
+			s.reportError(fmt.Errorf("can't open gallery: %v", err))
+			g.destroy()
+			return
+		}
+		g.thumbs = append(g.thumbs, tex)
+	}
+	s.gallery = g
+	s.selectPhoto(0)
+}
+
+// closeGallery goes back to the live view.
+func (s *Selfies) closeGallery() {
+	if s.gallery != nil {
+		s.gallery.destroy()
+		s.gallery = nil
+	}
+}
+
+func (g *gallery) destroy() {
+	for _, tex := range append(g.thumbs, g.large, g.label) {
+		if tex != nil {
+			tex.Destroy()
+		}
+	}
+}
+
+// galleryButton handles a button press while the gallery is open.  Shoot
+// closes the gallery and starts taking photos.
+func (s *Selfies) galleryButton(button int) {
+	g := s.gallery
+	g.lastPress = time.Now()
+	switch button {
+	case ButtonGallery:
+		s.closeGallery()
+	case ButtonShoot:
+		s.closeGallery()
+		s.perform(s.session.Handle(EventShoot))
+	case ButtonNext:
+		s.selectPhoto((g.index + 1) % len(g.items))
+	case ButtonPrev:
+		s.selectPhoto((g.index + len(g.items) - 1) % len(g.items))
+	case ButtonPrint:
+		item := g.items[g.index]
+		if _, err := s.printQueue.Submit(s.storage.Path(item.file), item.session); err != nil {
+			s.reportError(fmt.Errorf("not printing: %v", err))
+		} else if s.record != nil && s.record.ID == item.session {
+			s.record.Prints++
+			s.saveRecord()
+		}
+	case ButtonDelete:
+		if time.Since(g.deleteArmed) > deleteConfirmTime {
+			g.deleteArmed = time.Now()
+			s.reportError(fmt.Errorf("press delete again to delete this photo"))
+			return
+		}
+		g.deleteArmed = time.Time{}
+		s.deletePhoto()
+	}
+}
+
+// deletePhoto moves the selected photo out of the gallery.
+func (s *Selfies) deletePhoto() {
+	g := s.gallery
+	item := g.items[g.index]
+	dir := s.storage.Path(deletedDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		s.reportError(fmt.Errorf("failed to delete photo: %v", err))
+		return
+	}
+	if err := os.Rename(s.storage.Path(item.file), filepath.Join(dir, item.file)); err != nil {
+		s.reportError(fmt.Errorf("failed to delete photo: %v", err))
+		return
+	}
+	log.Printf("deleted %s", item.file)
+	s.forgetPhoto(item)
+
+	g.items = append(g.items[:g.index], g.items[g.index+1:]...)
+	if len(g.items) == 0 {
+		s.closeGallery()
+		return
+	}
+	s.selectPhoto(g.index % len(g.items))
+}
+
+// forgetPhoto takes a deleted photo out of its session's record, and out of
+// the thumbnail grid and print button.
+func (s *Selfies) forgetPhoto(item galleryItem) {
+	filename := s.storage.Path(item.file)
+	for i, f := range s.snapfiles {
+		if f == filename {
+			s.snapfiles[i] = ""
+			if s.snaps[i] != nil {
+				s.snaps[i].Destroy()
+				s.snaps[i] = nil
+			}
+		}
+	}
+	if s.printable == filename {
+		s.printable = ""
+	}
+
+	rec := s.record
+	if rec == nil || rec.ID != item.session {
+		records, err := LoadSessionRecords(s.storage)
+		if err != nil {
+			s.reportError(err)
+			return
+		}
+		rec = nil
+		for _, r := range records {
+			if r.ID == item.session {
+				rec = r
+			}
+		}
+		if rec == nil {
+			return
+		}
+	}
+	if item.print {
+		rec.Print = ""
+	}
+	for i, shot := range rec.Shots {
+		if shot.File == item.file {
+			rec.Shots = append(rec.Shots[:i], rec.Shots[i+1:]...)
+			break
+		}
+	}
+	s.writeRecord(rec)
+}
+
+// selectPhoto shows item i large, with the ones after it as thumbnails.  The
+// images are loaded in the pipeline and drawn when they're ready.
+func (s *Selfies) selectPhoto(i int) {
+	g := s.gallery
+	g.index = i
+	g.gen++
+	g.loaded = false
+	g.deleteArmed = time.Time{}
+
+	label, err := s.renderText(fmt.Sprintf("%d / %d", i+1, len(g.items)), sdl.Color{R: 255, G: 255, B: 255, A: 255})
+	if err != nil {
+		log.Printf("failed to render gallery label: %v", err)
+	} else {
+		if g.label != nil {
+			g.label.Destroy()
+		}
+		g.label = label
+	}
+
+	var files []string
+	for j := 0; j <= len(g.thumbs) && i+j < len(g.items); j++ {
+		files = append(files, s.storage.Path(g.items[i+j].file))
+	}
+	gen, l := g.gen, s.layout
+	err = s.pipeline.Submit(func() func() {
+		large, err := loadFitted(files[0], int(l.Review.W), int(l.Review.H))
+		if err != nil {
+			log.Printf("gallery: %v", err)
+		}
+		var thumbs []*image.RGBA
+		for _, f := range files[1:] {
+			thumb, err := loadFitted(f, int(l.ThumbWidth), int(l.ThumbHeight))
+			if err != nil {
+				log.Printf("gallery: %v", err)
+			}
+			thumbs = append(thumbs, thumb)
+		}
+		return func() {
+			if s.gallery != g || g.gen != gen {
+				return
+			}
+			if large != nil {
+				g.large.Update(&sdl.Rect{X: 0, Y: 0, W: l.Review.W, H: l.Review.H}, large.Pix, large.Stride)
+			}
+			for j, thumb := range thumbs {
+				if thumb != nil {
+					g.thumbs[j].Update(&sdl.Rect{X: 0, Y: 0, W: l.ThumbWidth, H: l.ThumbHeight}, thumb.Pix, thumb.Stride)
+				}
+			}
+			g.loaded = true
+		}
+	})
+	if err != nil {
+		log.Printf("gallery: %v", err)
+	}
+}
+
+// loadFitted reads a saved photo or print and scales it to fit in w x h,
+// centered on black.
+func loadFitted(filename string, w, h int) (*image.RGBA, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	img, err := jpeg.Decode(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	b := img.Bounds()
+	fit := fitRect(sdl.Rect{W: int32(w), H: int32(h)}, int32(b.Dx()), int32(b.Dy()))
+	scaled, err := convert.Image(img, b, int(fit.W), int(fit.H))
+	if err != nil {
+		return nil, err
+	}
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(out, out.Bounds(), image.Black, image.Point{}, draw.Src)
+	draw.Draw(out, scaled.Bounds().Add(image.Pt(int(fit.X), int(fit.Y))), scaled, image.Point{}, draw.Src)
+	return out, nil
+}
+
+// drawGallery draws the selected photo where the live view goes, and the
+// photos after it in the thumbnail grid.  It closes the gallery if it's been
+// left alone for galleryTimeout.
+func (s *Selfies) drawGallery() {
+	g, l := s.gallery, &s.layout
+	if time.Since(g.lastPress) > galleryTimeout {
+		s.closeGallery()
+		return
+	}
+	if !g.loaded {
+		return
+	}
+	s.renderer.Copy(g.large, &sdl.Rect{X: 0, Y: 0, W: l.Review.W, H: l.Review.H}, &l.Review)
+	for j := range l.Thumbs {
+		if g.index+j+1 < len(g.items) {
+			s.renderer.Copy(g.thumbs[j], &sdl.Rect{X: 0, Y: 0, W: l.ThumbWidth, H: l.ThumbHeight}, &l.Thumbs[j])
+		}
+	}
+	if g.label != nil {
+		_, _, texWidth, texHeight, _ := g.label.Query()
+		s.renderer.Copy(g.label,
+			&sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight},
+			&sdl.Rect{X: l.Review.X + (l.Review.W-texWidth)/2, Y: l.Review.Y + l.Review.H - texHeight, W: texWidth, H: texHeight})
+	}
+}
@@ -0,0 +1,124 @@
+package selfies
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/redbo/selfies/convert"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// checkGolden compares img to testdata/<name>.png, or writes it there with -update.
+func checkGolden(t *testing.T, name string, img *image.RGBA) {
+	t.Helper()
+	filename := filepath.Join("testdata", name+".png")
+	if *update {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	fp, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	defer fp.Close()
+	want, err := png.Decode(fp)
+	if err != nil {
+		t.Fatalf("%s: %v", filename, err)
+	}
+	if want.Bounds() != img.Bounds() {
+		t.Fatalf("%s: got a %v image, want %v", name, img.Bounds(), want.Bounds())
+	}
+	b := img.Bounds()
+	bad := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			got, w := img.RGBAAt(x, y), color.RGBAModel.Convert(want.At(x, y)).(color.RGBA)
+			if got != w {
+				if bad == 0 {
+					t.Errorf("%s: pixel %d,%d is %v, want %v", name, x, y, got, w)
+				}
+				bad++
+			}
+		}
+	}
+	if bad > 0 {
+		t.Errorf("%s: %d pixels differ from %s", name, bad, filename)
+	}
+}
+
+const patternWidth, patternHeight = 96, 64
+
+// patternPhoto is frame n of the test pattern, made into a w x h photo.
+func patternPhoto(t *testing.T, n, w, h int) *image.RGBA {
+	p := NewTestPattern(patternWidth, patternHeight).(*testPattern)
+	crop := convert.CenterCrop(image.Rect(0, 0, patternWidth, patternHeight), photoAspectW, photoAspectH)
+	img, err := convertFrame(p.frame(n), FormatYUYV, patternWidth, patternHeight, crop, w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestPatternFrames(t *testing.T) {
+	p := NewTestPattern(patternWidth, patternHeight)
+	if _, err := p.ReadFrame(); err == nil {
+		t.Error("read a frame before starting")
+	}
+	p.Start()
+	// a frame every other read, so the render loop gets one a step
+	var frames [][]byte
+	for i := 0; i < 6; i++ {
+		f, err := p.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f != nil; got != (i%2 == 0) {
+			t.Fatalf("read %d returned a frame: %v", i, got)
+		}
+		if f != nil {
+			frames = append(frames, f)
+		}
+	}
+	if bytes.Equal(frames[0], frames[1]) {
+		t.Error("the block didn't move")
+	}
+	if !bytes.Equal(frames[2], p.(*testPattern).frame(3)) {
+		t.Error("the third frame isn't frame 3")
+	}
+}
+
+func TestPatternGolden(t *testing.T) {
+	for _, n := range []int{1, 6} {
+		checkGolden(t, fmt.Sprintf("pattern-%d", n), patternPhoto(t, n, patternWidth, patternHeight))
+	}
+}
+
+func TestFiltersGolden(t *testing.T) {
+	for _, f := range Filters {
+		img := patternPhoto(t, 4, patternWidth/2, patternHeight/2)
+		f.RGBA(img)
+		checkGolden(t, "filter-"+f.Name, img)
+	}
+}
+
+func TestStripGolden(t *testing.T) {
+	var shots []image.Image
+	for _, n := range []int{1, 4, 7} {
+		shots = append(shots, patternPhoto(t, n, patternWidth/2, patternHeight/2))
+	}
+	checkGolden(t, "strip", composeStrip(shots, 2, 4))
+}
@@ -0,0 +1,85 @@
+package selfies
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// NewTetheredCamera returns the still camera selected by cfg.Type.  It returns
+// nil if there isn't one.
+func NewTetheredCamera(cfg TetheredConfig) (StillCapturer, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "gphoto2":
+		if _, err := exec.LookPath("gphoto2"); err != nil {
+			return nil, err
+		}
+		return &gphoto2Camera{port: cfg.Port, timeout: cfg.Timeout.Duration}, nil
+	}
+	return nil, fmt.Errorf("unknown tethered camera type %q", cfg.Type)
+}
+
+// gphoto2Camera triggers a camera tethered over USB with the gphoto2 command
+// and downloads the photo it takes.  The camera should be set to shoot JPEG.
+type gphoto2Camera struct {
+	// the camera can only take one photo at a time
+	mu      sync.Mutex
+	port    string
+	timeout time.Duration
+	// the model from the last photo's EXIF
+	model string
+}
+
+func (g *gphoto2Camera) Name() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.model == "" {
+		return "gphoto2 tethered camera"
+	}
+	return g.model
+}
+
+// CaptureStill takes a photo, turned upright if the camera says it was held
+// on its side.  It's downloaded to a temporary directory, since only the
+// photo saved from it is kept.
+func (g *gphoto2Camera) CaptureStill() (image.Image, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dir, err := os.MkdirTemp("", "selfies-gphoto2-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "camera.jpg")
+	args := []string{"--capture-image-and-download", "--force-overwrite", "--filename", filename}
+	if g.port != "" {
+		args = append(args, "--port", g.port)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	if err := runCommand(ctx, "gphoto2", args...); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("camera photo: %v", err)
+	}
+	model, orientation := readEXIF(data)
+	if model != "" {
+		g.model = model
+	}
+	return orient(img, orientation)
+}
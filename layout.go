@@ -0,0 +1,102 @@
+package selfies
+
+import (
+	"fmt"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Layout is where everything goes on the screen.
+type Layout struct {
+	// Preview is where the live view is drawn, and PreviewSrc is the part of
+	// the camera frame that's shown there, cropped to keep its aspect ratio.
+	Preview    sdl.Rect
+	PreviewSrc sdl.Rect
+	// Review is where a photo that was just taken is shown, over the live view.
+	Review sdl.Rect
+	// Thumbs are the recent photos, newest first.  The print label goes above the first one.
+	Thumbs []sdl.Rect
+	// ThumbWidth and ThumbHeight are the size of each thumbnail.
+	ThumbWidth  int32
+	ThumbHeight int32
+}
+
+// photo aspect ratio, after developPhoto center crops it
+const photoAspectW, photoAspectH = 3, 2
+
+// ComputeLayout lays out a screen of the given size for a camera of the
+// given size.  name is "portrait", "landscape", or "auto" to pick one based
+// on the screen's shape.
+func ComputeLayout(name string, screenWidth, screenHeight int32, camWidth, camHeight int) (Layout, error) {
+	if name == "" || name == "auto" {
+		if screenWidth > screenHeight {
+			name = "landscape"
+		} else {
+			name = "portrait"
+		}
+	}
+	switch name {
+	case "portrait":
+		return portraitLayout(screenWidth, screenHeight, camWidth, camHeight), nil
+	case "landscape":
+		return landscapeLayout(screenWidth, screenHeight, camWidth, camHeight), nil
+	}
+	return Layout{}, fmt.Errorf("unknown layout %q", name)
+}
+
+// portraitLayout puts the live view across the top of the screen and a 2x2
+// grid of thumbnails in the bottom half.
+func portraitLayout(screenWidth, screenHeight int32, camWidth, camHeight int) Layout {
+	gap := screenWidth / 22
+	previewArea := sdl.Rect{X: 0, Y: 0, W: screenWidth, H: screenHeight * 3 / 8}
+	l := Layout{
+		Preview:    previewArea,
+		PreviewSrc: fitRect(sdl.Rect{W: int32(camWidth), H: int32(camHeight)}, previewArea.W, previewArea.H),
+		Review:     fitRect(previewArea, photoAspectW, photoAspectH),
+	}
+	l.ThumbWidth = (screenWidth - gap) / 2
+	l.ThumbHeight = l.ThumbWidth * photoAspectH / photoAspectW
+	top := screenHeight / 2
+	rowGap := (screenHeight - top - 2*l.ThumbHeight) / 2
+	l.Thumbs = gridRects(0, top, l.ThumbWidth, l.ThumbHeight, gap, rowGap)
+	return l
+}
+
+// landscapeLayout puts the live view on the left two thirds of the screen and
+// a 2x2 grid of thumbnails on the right.
+func landscapeLayout(screenWidth, screenHeight int32, camWidth, camHeight int) Layout {
+	gap := screenHeight / 22
+	previewArea := sdl.Rect{X: 0, Y: 0, W: screenWidth * 2 / 3, H: screenHeight}
+	l := Layout{
+		Preview:    previewArea,
+		PreviewSrc: fitRect(sdl.Rect{W: int32(camWidth), H: int32(camHeight)}, previewArea.W, previewArea.H),
+		Review:     fitRect(previewArea, photoAspectW, photoAspectH),
+	}
+	gridWidth := screenWidth - previewArea.W - gap
+	l.ThumbWidth = (gridWidth - gap) / 2
+	l.ThumbHeight = l.ThumbWidth * photoAspectH / photoAspectW
+	rowGap := (screenHeight - 2*l.ThumbHeight) / 3
+	l.Thumbs = gridRects(previewArea.W+gap, rowGap, l.ThumbWidth, l.ThumbHeight, gap, rowGap)
+	return l
+}
+
+// gridRects returns the rects of a 2x2 grid with its top left corner at x, y.
+func gridRects(x, y, w, h, colGap, rowGap int32) []sdl.Rect {
+	return []sdl.Rect{
+		{X: x, Y: y, W: w, H: h},
+		{X: x + w + colGap, Y: y, W: w, H: h},
+		{X: x, Y: y + h + rowGap, W: w, H: h},
+		{X: x + w + colGap, Y: y + h + rowGap, W: w, H: h},
+	}
+}
+
+// fitRect returns the largest rect with the aspect ratio w:h that fits in area, centered on it.
+func fitRect(area sdl.Rect, w, h int32) sdl.Rect {
+	r := sdl.Rect{W: area.W, H: area.W * h / w}
+	if r.H > area.H {
+		r = sdl.Rect{W: area.H * w / h, H: area.H}
+	}
+	r.X = area.X + (area.W-r.W)/2
+	r.Y = area.Y + (area.H-r.H)/2
+	return r
+}
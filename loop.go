@@ -0,0 +1,313 @@
+package selfies
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/redbo/selfies/convert"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// a finished loop plays in the review area this many times
+const loopPlays = 3
+
+// loopRecording is the frames kept while a loop is being recorded.  They're
+// copied as they come from the camera and converted once recording stops.
+type loopRecording struct {
+	frames        [][]byte
+	format        PixelFormat
+	width, height int
+	last          time.Time
+	taken         time.Time
+}
+
+// loopPlayback is a finished loop being shown in the review area.
+type loopPlayback struct {
+	tex     *sdl.Texture
+	frames  []*image.RGBA
+	delay   time.Duration
+	started time.Time
+	shown   int
+}
+
+// startLoop starts a new session that records a loop.
+func (s *Selfies) startLoop() {
+	s.startSession()
+	s.recording = &loopRecording{format: s.texFormat, width: s.texWidth, height: s.texHeight, taken: time.Now()}
+}
+
+// recordFrame keeps the current frame for the loop being recorded, if it's
+// time for the next one.
+func (s *Selfies) recordFrame() {
+	r := s.recording
+	if r.format != s.texFormat || r.width != s.texWidth || r.height != s.texHeight {
+		// the camera came back different; the loop ends early
+		return
+	}
+	if time.Since(r.last) < time.Second/time.Duration(s.cfg.Loop.FPS) {
+		return
+	}
+	r.last = time.Now()
+	r.frames = append(r.frames, append([]byte(nil), s.frame...))
+}
+
+// finishLoop hands the recorded frames to the pipeline to be turned into a
+// loop and a boomerang, saved, and played back.
+func (s *Selfies) finishLoop() {
+	r := s.recording
+	s.recording = nil
+	if r == nil || len(r.frames) == 0 {
+		s.reportError(fmt.Errorf("no frames for the loop"))
+		return
+	}
+	session := s.sessionID
+	key, filter, overlays := s.key, s.filter, s.overlays
+	w := s.cfg.Loop.Width
+	h := w * photoAspectH / photoAspectW
+	delay := time.Second / time.Duration(s.cfg.Loop.FPS)
+	thumbWidth, thumbHeight := int(s.layout.ThumbWidth), int(s.layout.ThumbHeight)
+	err := s.pipeline.Submit(func() func() {
+		crop := convert.CenterCrop(image.Rect(0, 0, r.width, r.height), photoAspectW, photoAspectH)
+		var frames []*image.RGBA
+		for _, frame := range r.frames {
+			img, err := convertFrame(frame, r.format, r.width, r.height, crop, w, h)
+			if err != nil {
+				log.Printf("BAD FRAME: %v", err)
+				continue
+			}
+			if key != nil {
+				key.RGBA(img)
+			}
+			filter.RGBA(img)
+			burnOverlays(img, overlays)
+			frames = append(frames, img)
+		}
+		if len(frames) == 0 {
+			return func() { s.reportError(fmt.Errorf("no frames for the loop")) }
+		}
+		boomerang := boomerangFrames(frames)
+		var clips []ClipRecord
+		for _, kind := range []string{"loop", "boomerang"} {
+			order := frames
+			if kind == "boomerang" {
+				order = boomerang
+			}
+			filename, err := s.storage.WriteFile(fmt.Sprintf("%s-%s.gif", session, kind), func(w io.Writer) error {
+				return gif.EncodeAll(w, encodeLoop(order, delay))
+			})
+			if err != nil {
+				return func() { s.reportError(fmt.Errorf("failed to save %s: %v", kind, err)) }
+			}
+			clips = append(clips, ClipRecord{File: filepath.Base(filename), Kind: kind, Taken: r.taken})
+		}
+		thumb, err := convert.Image(frames[0], frames[0].Bounds(), thumbWidth, thumbHeight)
+		if err != nil {
+			log.Printf("failed to make thumbnail: %v", err)
+		}
+		return func() { s.loopDeveloped(session, boomerang, delay, thumb, clips) }
+	})
+	if err != nil {
+		s.reportError(fmt.Errorf("dropping loop: %v", err))
+	}
+}
+
+// loopDeveloped is called back on the render loop when a loop has been
+// saved.  It goes in the thumbnail grid, though it can't be printed, and
+// plays in the review area.
+func (s *Selfies) loopDeveloped(session string, frames []*image.RGBA, delay time.Duration, thumb *image.RGBA, clips []ClipRecord) {
+	if thumb != nil {
+		s.pushThumb(thumb, "")
+	}
+	if session != s.sessionID {
+		return
+	}
+	s.record.Clips = append(s.record.Clips, clips...)
+	s.saveRecord()
+
+	s.stopPlayback()
+	b := frames[0].Bounds()
+	tex, err := s.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, int32(b.Dx()), int32(b.Dy()))
+	if err != nil {
+		log.Printf("can't play loop: %v", err)
+		return
+	}
+	s.playback = &loopPlayback{tex: tex, frames: frames, delay: delay, started: time.Now(), shown: -1}
+}
+
+// stopPlayback stops showing the last loop or video.
+func (s *Selfies) stopPlayback() {
+	if s.playback != nil {
+		s.playback.tex.Destroy()
+		s.playback = nil
+	}
+	if s.videoPlayback != nil {
+		close(s.videoPlayback.quit)
+		s.videoPlayback.tex.Destroy()
+		s.videoPlayback = nil
+	}
+}
+
+// drawPlayback draws the current frame of the loop being played back.
+func (s *Selfies) drawPlayback() {
+	p := s.playback
+	i := int(time.Since(p.started) / p.delay)
+	if i >= loopPlays*len(p.frames) {
+		s.stopPlayback()
+		return
+	}
+	b := p.frames[0].Bounds()
+	if i %= len(p.frames); i != p.shown {
+		p.tex.Update(&sdl.Rect{X: 0, Y: 0, W: int32(b.Dx()), H: int32(b.Dy())}, p.frames[i].Pix, p.frames[i].Stride)
+		p.shown = i
+	}
+	s.renderer.Copy(p.tex, &sdl.Rect{X: 0, Y: 0, W: int32(b.Dx()), H: int32(b.Dy())}, &s.layout.Review)
+}
+
+// boomerangFrames plays frames forwards and then backwards, without showing
+// either end twice, so the loop doesn't jump when it starts over.
+func boomerangFrames(frames []*image.RGBA) []*image.RGBA {
+	out := append([]*image.RGBA(nil), frames...)
+	for i := len(frames) - 2; i > 0; i-- {
+		out = append(out, frames[i])
+	}
+	return out
+}
+
+// encodeLoop turns frames into a GIF that loops forever.  All the frames
+// share one palette picked from all of them, and aren't dithered, so the
+// colors hold still from one frame to the next.
+func encodeLoop(frames []*image.RGBA, delay time.Duration) *gif.GIF {
+	palette := medianCut(frames, 256)
+	lut := newPaletteLUT(palette)
+	g := &gif.GIF{}
+	// converted frames are shared by both ends of a boomerang
+	done := make(map[*image.RGBA]*image.Paletted)
+	for _, frame := range frames {
+		p, ok := done[frame]
+		if !ok {
+			p = lut.paletted(frame)
+			done[frame] = p
+		}
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, int(delay/(10*time.Millisecond)))
+	}
+	return g
+}
+
+// colorBox is a set of colors being split up by medianCut.
+type colorBox [][3]uint8
+
+// widest returns the channel the colors in the box vary most in, and by how much.
+func (b colorBox) widest() (int, int) {
+	channel, width := 0, -1
+	for c := 0; c < 3; c++ {
+		lo, hi := 255, 0
+		for _, p := range b {
+			if int(p[c]) < lo {
+				lo = int(p[c])
+			}
+			if int(p[c]) > hi {
+				hi = int(p[c])
+			}
+		}
+		if hi-lo > width {
+			channel, width = c, hi-lo
+		}
+	}
+	return channel, width
+}
+
+func (b colorBox) average() color.Color {
+	var sum [3]int
+	for _, p := range b {
+		for c := range sum {
+			sum[c] += int(p[c])
+		}
+	}
+	n := len(b)
+	return color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 255}
+}
+
+// medianCut picks a palette of up to n colors for frames, by splitting a
+// sample of their pixels in half along its widest channel until there are n
+// groups, then averaging each group.
+func medianCut(frames []*image.RGBA, n int) color.Palette {
+	const maxSamples = 1 << 16
+	total := 0
+	for _, f := range frames {
+		total += len(f.Pix) / 4
+	}
+	step := total/maxSamples + 1
+	var all colorBox
+	for _, f := range frames {
+		for i := 0; i < len(f.Pix); i += 4 * step {
+			all = append(all, [3]uint8{f.Pix[i], f.Pix[i+1], f.Pix[i+2]})
+		}
+	}
+	boxes := []colorBox{all}
+	for len(boxes) < n {
+		// split the box with the widest spread of colors
+		best, bestChannel, bestWidth := -1, 0, 0
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			if c, w := b.widest(); w > bestWidth {
+				best, bestChannel, bestWidth = i, c, w
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		sort.Slice(b, func(i, j int) bool { return b[i][bestChannel] < b[j][bestChannel] })
+		boxes[best] = b[:len(b)/2]
+		boxes = append(boxes, b[len(b)/2:])
+	}
+	palette := make(color.Palette, 0, len(boxes))
+	for _, b := range boxes {
+		palette = append(palette, b.average())
+	}
+	return palette
+}
+
+// paletteLUT finds the nearest palette entry for colors quickly, by
+// remembering it for each color with 5 bits per channel.
+type paletteLUT struct {
+	palette color.Palette
+	index   [1 << 15]int16
+}
+
+func newPaletteLUT(palette color.Palette) *paletteLUT {
+	lut := &paletteLUT{palette: palette}
+	for i := range lut.index {
+		lut.index[i] = -1
+	}
+	return lut
+}
+
+func (lut *paletteLUT) paletted(img *image.RGBA) *image.Paletted {
+	b := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), lut.palette)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		dst := out.Pix[out.PixOffset(0, y-b.Min.Y):]
+		for i := 0; i < len(row); i += 4 {
+			k := int(row[i]>>3)<<10 | int(row[i+1]>>3)<<5 | int(row[i+2]>>3)
+			if lut.index[k] < 0 {
+				// match on the middle of the 5 bit cell
+				c := color.RGBA{row[i]&^7 | 4, row[i+1]&^7 | 4, row[i+2]&^7 | 4, 255}
+				lut.index[k] = int16(lut.palette.Index(c))
+			}
+			dst[i/4] = uint8(lut.index[k])
+		}
+	}
+	return out
+}
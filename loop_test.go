@@ -0,0 +1,110 @@
+package selfies
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// gradient is a w x h image whose colors change along each axis, offset by n.
+func gradient(w, h, n int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x*255/w + n), uint8(y*255/h + n), uint8((x + y + n) * 7), 255})
+		}
+	}
+	return img
+}
+
+func TestMedianCutSize(t *testing.T) {
+	frames := []*image.RGBA{gradient(64, 48, 0), gradient(64, 48, 40)}
+	for _, n := range []int{1, 2, 16, 256} {
+		if p := medianCut(frames, n); len(p) == 0 || len(p) > n {
+			t.Errorf("asked for %d colors, got %d", n, len(p))
+		}
+	}
+	// a box of one color can't be split, so a flat image has a palette of one
+	flat := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	if p := medianCut([]*image.RGBA{flat}, 256); len(p) != 1 {
+		t.Errorf("got %d colors for a flat image", len(p))
+	}
+}
+
+func TestEncodeLoopTwoColors(t *testing.T) {
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{10, 20, 250, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 10, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 10; x++ {
+			if (x+y)%3 == 0 {
+				img.SetRGBA(x, y, red)
+			} else {
+				img.SetRGBA(x, y, blue)
+			}
+		}
+	}
+	g := encodeLoop([]*image.RGBA{img}, 100*time.Millisecond)
+	if len(g.Image) != 1 {
+		t.Fatalf("got %d frames, want 1", len(g.Image))
+	}
+	p := g.Image[0]
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 10; x++ {
+			if got, want := color.RGBAModel.Convert(p.At(x, y)), img.At(x, y); got != want {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeLoopDelays(t *testing.T) {
+	frames := []*image.RGBA{gradient(16, 8, 0), gradient(16, 8, 10), gradient(16, 8, 20)}
+	g := encodeLoop(boomerangFrames(frames), 125*time.Millisecond)
+	if len(g.Image) != 4 || len(g.Delay) != 4 {
+		t.Fatalf("got %d frames and %d delays, want 4", len(g.Image), len(g.Delay))
+	}
+	for i, d := range g.Delay {
+		if d != 12 {
+			t.Errorf("frame %d has a delay of %d, want 12", i, d)
+		}
+	}
+	// both ends of the boomerang share the frame they show
+	if g.Image[1] != g.Image[3] {
+		t.Error("the middle frame was converted twice")
+	}
+}
+
+func TestBoomerangFrames(t *testing.T) {
+	for n := 1; n <= 5; n++ {
+		var frames []*image.RGBA
+		for i := 0; i < n; i++ {
+			frames = append(frames, gradient(2, 2, i))
+		}
+		got := boomerangFrames(frames)
+		want := 2*n - 2
+		if n == 1 {
+			want = 1
+		}
+		if len(got) != want {
+			t.Errorf("%d frames: got %d, want %d", n, len(got), want)
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if got[i] != frames[i] {
+				t.Errorf("%d frames: frame %d isn't played forwards", n, i)
+			}
+		}
+		for i := n; i < len(got); i++ {
+			if got[i] != frames[2*n-2-i] {
+				t.Errorf("%d frames: frame %d isn't played backwards", n, i)
+			}
+		}
+		// playing it over and over never shows either end twice in a row
+		for i := range got {
+			if len(got) > 1 && got[i] == got[(i+1)%len(got)] {
+				t.Errorf("%d frames: frame %d repeats", n, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package selfies
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/redbo/selfies/convert"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// overlay is a picture drawn over the photos, like a frame around the edge
+// or a hat for someone to stand under.  It's drawn on the live view with the
+// GPU and into the saved photo on the CPU, in the same place on both.
+type overlay struct {
+	OverlayConfig
+	img image.Image
+	tex *sdl.Texture
+}
+
+// loadOverlay reads an overlay's image and uploads it to a texture.
+func loadOverlay(renderer *sdl.Renderer, cfg OverlayConfig) (*overlay, error) {
+	img, err := loadImage(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("overlay %s: %v", cfg.File, err)
+	}
+	// SDL blends with straight alpha, which is what NRGBA holds
+	b := img.Bounds()
+	pix := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(pix, pix.Bounds(), img, b.Min, draw.Src)
+	tex, err := renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STATIC, int32(b.Dx()), int32(b.Dy()))
+	if err != nil {
+		return nil, fmt.Errorf("overlay %s: error creating texture: %v", cfg.File, err)
+	}
+	if err = tex.Update(nil, pix.Pix, pix.Stride); err != nil {
+		tex.Destroy()
+		return nil, fmt.Errorf("overlay %s: %v", cfg.File, err)
+	}
+	tex.SetBlendMode(sdl.BLENDMODE_BLEND)
+	return &overlay{OverlayConfig: cfg, img: pix, tex: tex}, nil
+}
+
+// area returns the box the overlay is fitted into on a photo covering r.
+func (o *overlay) area(r image.Rectangle) image.Rectangle {
+	if o.W == 0 && o.H == 0 {
+		return r
+	}
+	x := func(f float64) int { return r.Min.X + int(f*float64(r.Dx())+0.5) }
+	y := func(f float64) int { return r.Min.Y + int(f*float64(r.Dy())+0.5) }
+	return image.Rect(x(o.X), y(o.Y), x(o.X+o.W), y(o.Y+o.H))
+}
+
+// burnOverlays draws the overlays into a photo, in order.
+func burnOverlays(photo *image.RGBA, overlays []*overlay) {
+	for _, o := range overlays {
+		drawFit(photo, o.area(photo.Bounds()), o.img)
+	}
+}
+
+// overlayLayer returns the overlays drawn on a transparent w x h image, to
+// be drawn over many frames of that size, or nil if there are none.
+func overlayLayer(overlays []*overlay, w, h int) *image.RGBA {
+	if len(overlays) == 0 {
+		return nil
+	}
+	layer := image.NewRGBA(image.Rect(0, 0, w, h))
+	burnOverlays(layer, overlays)
+	return layer
+}
+
+// drawOverlays draws the overlays over the live view, where they'll be on
+// the photo cut from it.
+func (s *Selfies) drawOverlays() {
+	if len(s.overlays) == 0 {
+		return
+	}
+	l := &s.layout
+	crop := convert.CenterCrop(image.Rect(0, 0, s.texWidth, s.texHeight), photoAspectW, photoAspectH)
+	// from camera frame coordinates to the screen
+	toScreen := func(r sdl.Rect) sdl.Rect {
+		return sdl.Rect{
+			X: l.Preview.X + (r.X-l.PreviewSrc.X)*l.Preview.W/l.PreviewSrc.W,
+			Y: l.Preview.Y + (r.Y-l.PreviewSrc.Y)*l.Preview.H/l.PreviewSrc.H,
+			W: r.W * l.Preview.W / l.PreviewSrc.W,
+			H: r.H * l.Preview.H / l.PreviewSrc.H,
+		}
+	}
+	// overlays on the edge of the photo can stick out past the live view
+	s.renderer.SetClipRect(&l.Preview)
+	defer s.renderer.SetClipRect(nil)
+	for _, o := range s.overlays {
+		a := o.area(crop)
+		b := o.img.Bounds()
+		fit := fitRect(sdl.Rect{X: int32(a.Min.X), Y: int32(a.Min.Y), W: int32(a.Dx()), H: int32(a.Dy())}, int32(b.Dx()), int32(b.Dy()))
+		dst := toScreen(fit)
+		s.renderer.Copy(o.tex, nil, &dst)
+	}
+}
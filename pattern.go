@@ -0,0 +1,80 @@
+package selfies
+
+import (
+	"errors"
+	"image/color"
+)
+
+var patternBars = []color.RGBA{
+	{255, 255, 255, 255}, {255, 255, 0, 255}, {0, 255, 255, 255}, {0, 255, 0, 255},
+	{255, 0, 255, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 255},
+}
+
+type testPattern struct {
+	width   int
+	height  int
+	running bool
+	// whether the last ReadFrame returned a frame
+	sent  bool
+	count int
+}
+
+// NewTestPattern returns a FrameSource that generates color bars with a moving
+// block, for running the booth without a camera.  Every other ReadFrame
+// returns a new frame, so the render loop gets one each Step, and the block
+// moves with the number of frames rather than the time, so headless runs
+// look the same every time.
+func NewTestPattern(width, height int) FrameSource {
+	return &testPattern{width: width &^ 1, height: height}
+}
+
+func (p *testPattern) Format() (PixelFormat, int, int) {
+	return FormatYUYV, p.width, p.height
+}
+
+func (p *testPattern) Start() error {
+	p.running = true
+	return nil
+}
+
+func (p *testPattern) Stop() error {
+	p.running = false
+	return nil
+}
+
+func (p *testPattern) ReadFrame() ([]byte, error) {
+	if !p.running {
+		return nil, errors.New("test pattern is not started")
+	}
+	if p.sent {
+		p.sent = false
+		return nil, nil
+	}
+	p.sent = true
+	p.count++
+	return p.frame(p.count), nil
+}
+
+// frame draws the nth frame of the pattern.
+func (p *testPattern) frame(n int) []byte {
+	frame := make([]byte, p.width*p.height*2)
+	blockSize := p.height / 4
+	blockX := (n * 8) % (p.width + blockSize)
+	blockY := (p.height - blockSize) / 2
+	for y := 0; y < p.height; y++ {
+		row := frame[y*p.width*2:]
+		for x := 0; x < p.width; x += 2 {
+			c := patternBars[x*len(patternBars)/p.width]
+			if y >= blockY && y < blockY+blockSize && x >= blockX-blockSize && x < blockX {
+				c = color.RGBA{128, 128, 128, 255}
+			}
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			row[x*2], row[x*2+1], row[x*2+2], row[x*2+3] = yy, cb, yy, cr
+		}
+	}
+	return frame
+}
+
+func (p *testPattern) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package selfies
+
+import (
+	"errors"
+	"sync"
+)
+
+// errPipelineFull is returned by Submit when the workers are too far behind.
+var errPipelineFull = errors.New("too many photos waiting to be processed")
+
+// pipeline runs slow work, like converting and saving photos, on background
+// goroutines so the render loop never waits for it.  A job returns a function
+// that's run back on the render loop by Finish, to update the screen with
+// what it did.
+type pipeline struct {
+	jobs    chan func() func()
+	done    chan func()
+	wg      sync.WaitGroup
+	pending int
+}
+
+// newPipeline starts workers goroutines taking jobs from a queue of length queueLen.
+func newPipeline(workers, queueLen int) *pipeline {
+	p := &pipeline{
+		jobs: make(chan func() func(), queueLen),
+		done: make(chan func(), queueLen),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				p.done <- job()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues a job, or returns errPipelineFull if the queue is full.
+// It must be called from the render loop.
+func (p *pipeline) Submit(job func() func()) error {
+	select {
+	case p.jobs <- job:
+		p.pending++
+		return nil
+	default:
+		return errPipelineFull
+	}
+}
+
+// Go runs a long job, like encoding a video, on a goroutine of its own so it
+// doesn't hold up a worker.  Its UI update is run by Finish like any other
+// job's, and Close waits for it.  It must be called from the render loop.
+func (p *pipeline) Go(job func() func()) {
+	p.pending++
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.done <- job()
+	}()
+}
+
+// Finish runs the UI updates of any jobs that have finished.  It must be
+// called from the render loop.
+func (p *pipeline) Finish() {
+	for {
+		select {
+		case f := <-p.done:
+			p.pending--
+			if f != nil {
+				f()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Busy reports whether any submitted jobs haven't been finished.
+func (p *pipeline) Busy() bool {
+	return p.pending > 0
+}
+
+// Close waits for the queued jobs to run, throwing away their UI updates.
+func (p *pipeline) Close() error {
+	close(p.jobs)
+	go func() {
+		p.wg.Wait()
+		close(p.done)
+	}()
+	for range p.done {
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package selfies
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Printer sends a finished image file to paper.
+type Printer interface {
+	Print(filename string) error
+}
+
+// NewPrinter returns the printer backend selected by cfg.Type.
+func NewPrinter(cfg PrinterConfig) (Printer, error) {
+	switch cfg.Type {
+	case "", "obex":
+		return &obexPrinter{address: cfg.Address, channel: cfg.Channel, timeout: cfg.Timeout.Duration}, nil
+	case "lp":
+		return &lpPrinter{queue: cfg.Queue, options: cfg.Options, timeout: cfg.Timeout.Duration}, nil
+	case "folder":
+		return &folderPrinter{dir: cfg.Folder}, nil
+	case "fake":
+		return &FakePrinter{}, nil
+	}
+	return nil, fmt.Errorf("unknown printer type %q", cfg.Type)
+}
+
+// runCommand runs a command, turning a failure into an error with its exit status and output.
+// The command is killed if ctx is done first.
+func runCommand(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(out.String())
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(name), ctx.Err())
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("%s exited with status %d: %s", filepath.Base(name), exitErr.ExitCode(), msg)
+		}
+		return fmt.Errorf("%s: %v", filepath.Base(name), err)
+	}
+	return nil
+}
+
+// obexPrinter pushes files to a bluetooth photo printer with obexftp.
+type obexPrinter struct {
+	address string
+	channel int
+	timeout time.Duration
+}
+
+func (p *obexPrinter) Print(filename string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	return runCommand(ctx, "/usr/bin/obexftp", "--nopath", "--noconn", "--uuid", "none",
+		"--bluetooth", p.address, "--channel", strconv.Itoa(p.channel), "-p", filename)
+}
+
+// lpPrinter prints through CUPS with lp.
+type lpPrinter struct {
+	queue   string
+	options []string
+	timeout time.Duration
+}
+
+func (p *lpPrinter) Print(filename string) error {
+	var args []string
+	if p.queue != "" {
+		args = append(args, "-d", p.queue)
+	}
+	for _, o := range p.options {
+		args = append(args, "-o", o)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	return runCommand(ctx, "lp", append(args, "--", filename)...)
+}
+
+// folderPrinter copies files into a hot folder watched by some other print
+// software.  Files are written under a temporary name and renamed into place
+// so the watcher never sees a partial file.
+type folderPrinter struct {
+	dir string
+}
+
+func (p *folderPrinter) Print(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(p.dir, ".printing-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(p.dir, filepath.Base(filename)))
+}
+
+// FakePrinter remembers what it was asked to print instead of printing it.
+type FakePrinter struct {
+	mu      sync.Mutex
+	printed []string
+	// Err, if set, is returned from Print.
+	Err error
+}
+
+func (p *FakePrinter) Print(filename string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.printed = append(p.printed, filename)
+	return nil
+}
+
+// Printed returns the files printed so far.
+func (p *FakePrinter) Printed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.printed...)
+}
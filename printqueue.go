@@ -0,0 +1,275 @@
+package selfies
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// JobState is where a print job is in the queue.
+type JobState int
+
+const (
+	JobQueued JobState = iota
+	JobPrinting
+	JobDone
+	JobFailed // gave up after too many attempts
+)
+
+var jobStateNames = []string{"queued", "printing", "done", "failed"}
+
+func (s JobState) String() string {
+	if s >= 0 && int(s) < len(jobStateNames) {
+		return jobStateNames[s]
+	}
+	return fmt.Sprintf("JobState(%d)", int(s))
+}
+
+func (s JobState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *JobState) UnmarshalText(b []byte) error {
+	for i, name := range jobStateNames {
+		if name == string(b) {
+			*s = JobState(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown job state %q", b)
+}
+
+type PrintJob struct {
+	ID       int       `json:"id"`
+	File     string    `json:"file"`
+	Session  string    `json:"session"`
+	State    JobState  `json:"state"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	NextTry  time.Time `json:"next_try"`
+}
+
+// PrintQueue feeds jobs to a printer one at a time, retrying failures with
+// exponential backoff.  The queue is saved to a file after every change so
+// pending jobs are picked back up after a restart.
+type PrintQueue struct {
+	printer  Printer
+	filename string
+	cfg      PrintQueueConfig
+
+	mu     sync.Mutex
+	jobs   []*PrintJob
+	nextID int
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// the longest a failed job waits before its next attempt
+const maxRetryBackoff = 5 * time.Minute
+
+// finished jobs are only kept to count each session's copies, so only the
+// newest are kept, or the queue file would grow all event
+const maxFinishedJobs = 100
+
+// NewPrintQueue loads the queue saved in filename, if any, and starts printing.
+func NewPrintQueue(printer Printer, filename string, cfg PrintQueueConfig) (*PrintQueue, error) {
+	q := &PrintQueue{
+		printer:  printer,
+		filename: filename,
+		cfg:      cfg,
+		nextID:   1,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if err = json.Unmarshal(data, &q.jobs); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	for _, job := range q.jobs {
+		if job.State == JobPrinting { // we went down mid-print, so try it again
+			job.State = JobQueued
+		}
+		if job.ID >= q.nextID {
+			q.nextID = job.ID + 1
+		}
+	}
+	q.prune()
+	go q.run()
+	return q, nil
+}
+
+// Submit queues file to be printed, as long as session hasn't used up its copies.
+func (q *PrintQueue) Submit(file, session string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	copies := 0
+	for _, job := range q.jobs {
+		if job.Session == session && job.State != JobFailed {
+			copies++
+		}
+	}
+	if copies >= q.cfg.CopiesPerSession {
+		return 0, fmt.Errorf("session %s already has %d prints", session, copies)
+	}
+	job := &PrintJob{ID: q.nextID, File: file, Session: session, State: JobQueued, Created: time.Now()}
+	q.nextID++
+	q.jobs = append(q.jobs, job)
+	q.save()
+	q.poke()
+	return job.ID, nil
+}
+
+// Jobs returns a copy of every job the queue knows about.
+func (q *PrintQueue) Jobs() []PrintJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]PrintJob, len(q.jobs))
+	for i, job := range q.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+// Busy reports whether any jobs are waiting or printing.
+func (q *PrintQueue) Busy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if job.State == JobQueued || job.State == JobPrinting {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops the queue after any print in progress finishes.
+func (q *PrintQueue) Close() error {
+	close(q.stop)
+	<-q.done
+	return nil
+}
+
+func (q *PrintQueue) poke() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the queue out.  The caller must hold q.mu.
+func (q *PrintQueue) save() {
+	data, err := json.MarshalIndent(q.jobs, "", "  ")
+	if err != nil {
+		log.Printf("failed to encode print queue: %v", err)
+		return
+	}
+	tmp := q.filename + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, q.filename)
+	}
+	if err != nil {
+		log.Printf("failed to save print queue: %v", err)
+	}
+}
+
+// next returns the next job that's ready to print, or how long until one will be.
+func (q *PrintQueue) next() (*PrintJob, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wait := time.Duration(-1)
+	for _, job := range q.jobs {
+		if job.State != JobQueued {
+			continue
+		}
+		until := time.Until(job.NextTry)
+		if until <= 0 {
+			job.State = JobPrinting
+			job.Attempts++
+			q.save()
+			return job, 0
+		}
+		if wait < 0 || until < wait {
+			wait = until
+		}
+	}
+	return nil, wait
+}
+
+func (q *PrintQueue) finish(job *PrintJob, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err == nil {
+		job.State, job.Error = JobDone, ""
+	} else {
+		job.Error = err.Error()
+		if job.Attempts >= q.cfg.MaxAttempts {
+			job.State = JobFailed
+			log.Printf("giving up on print job %d (%s): %v", job.ID, job.File, err)
+		} else {
+			backoff := q.cfg.RetryBackoff.Duration << uint(job.Attempts-1)
+			if backoff > maxRetryBackoff || backoff <= 0 {
+				backoff = maxRetryBackoff
+			}
+			job.State = JobQueued
+			job.NextTry = time.Now().Add(backoff)
+			log.Printf("print job %d (%s) failed, retrying in %v: %v", job.ID, job.File, backoff, err)
+		}
+	}
+	q.prune()
+	q.save()
+}
+
+// prune drops the oldest finished jobs past maxFinishedJobs.  The caller must hold q.mu.
+func (q *PrintQueue) prune() {
+	finished := 0
+	for _, job := range q.jobs {
+		if job.State == JobDone || job.State == JobFailed {
+			finished++
+		}
+	}
+	kept := q.jobs[:0]
+	for _, job := range q.jobs {
+		if finished > maxFinishedJobs && (job.State == JobDone || job.State == JobFailed) {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	q.jobs = kept
+}
+
+func (q *PrintQueue) run() {
+	defer close(q.done)
+	for {
+		job, wait := q.next()
+		if job != nil {
+			q.finish(job, q.printer.Print(job.File))
+			continue
+		}
+		var timer *time.Timer
+		var retry <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			retry = timer.C
+		}
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
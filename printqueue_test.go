@@ -0,0 +1,219 @@
+package selfies
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// idleQueue is a PrintQueue that saves to a temp file but isn't running, so
+// its jobs only change when the test changes them.
+func idleQueue(t *testing.T, cfg PrintQueueConfig, jobs ...*PrintJob) *PrintQueue {
+	return &PrintQueue{
+		filename: filepath.Join(t.TempDir(), "printqueue.json"),
+		cfg:      cfg,
+		jobs:     jobs,
+		nextID:   len(jobs) + 1,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// waitIdle waits for q to have nothing left to print.
+func waitIdle(t *testing.T, q *PrintQueue) {
+	t.Helper()
+	for start := time.Now(); q.Busy(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("print queue still busy: %+v", q.Jobs())
+		}
+	}
+}
+
+func TestPrintQueueBackoff(t *testing.T) {
+	cfg := PrintQueueConfig{CopiesPerSession: 1, MaxAttempts: 5, RetryBackoff: Duration{time.Second}}
+	tests := []struct {
+		backoff  time.Duration
+		attempts int
+		state    JobState
+		wait     time.Duration
+	}{
+		{time.Second, 1, JobQueued, time.Second},
+		{time.Second, 2, JobQueued, 2 * time.Second},
+		{time.Second, 3, JobQueued, 4 * time.Second},
+		{time.Second, 4, JobQueued, 8 * time.Second},
+		{time.Second, 5, JobFailed, 0},
+		{time.Minute, 4, JobQueued, maxRetryBackoff},
+	}
+	for _, test := range tests {
+		cfg.RetryBackoff.Duration = test.backoff
+		job := &PrintJob{ID: 1, File: "a.jpg", State: JobPrinting, Attempts: test.attempts}
+		q := idleQueue(t, cfg, job)
+		before := time.Now()
+		q.finish(job, errors.New("out of paper"))
+		after := time.Now()
+		if job.State != test.state || job.Error != "out of paper" {
+			t.Errorf("backoff %v, attempt %d: job is %v with error %q, want %v", test.backoff, test.attempts, job.State, job.Error, test.state)
+		}
+		if test.state == JobQueued && (job.NextTry.Before(before.Add(test.wait)) || job.NextTry.After(after.Add(test.wait))) {
+			t.Errorf("backoff %v, attempt %d: retrying in %v, want %v", test.backoff, test.attempts, job.NextTry.Sub(before), test.wait)
+		}
+	}
+}
+
+func TestPrintQueueRetries(t *testing.T) {
+	tests := []struct {
+		err      error
+		state    JobState
+		attempts int
+		printed  []string
+	}{
+		{nil, JobDone, 1, []string{"a.jpg"}},
+		{errors.New("printer offline"), JobFailed, 3, nil},
+	}
+	for _, test := range tests {
+		printer := &FakePrinter{Err: test.err}
+		cfg := PrintQueueConfig{CopiesPerSession: 1, MaxAttempts: 3, RetryBackoff: Duration{time.Millisecond}}
+		q, err := NewPrintQueue(printer, filepath.Join(t.TempDir(), "printqueue.json"), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.Submit("a.jpg", "s1"); err != nil {
+			t.Fatal(err)
+		}
+		waitIdle(t, q)
+		q.Close()
+		jobs := q.Jobs()
+		if len(jobs) != 1 || jobs[0].State != test.state || jobs[0].Attempts != test.attempts {
+			t.Errorf("printer error %v: jobs are %+v, want one %v after %d attempts", test.err, jobs, test.state, test.attempts)
+		}
+		if got := printer.Printed(); !reflect.DeepEqual(got, test.printed) {
+			t.Errorf("printer error %v: printed %v, want %v", test.err, got, test.printed)
+		}
+	}
+}
+
+func TestPrintQueueCopiesPerSession(t *testing.T) {
+	q := idleQueue(t, PrintQueueConfig{CopiesPerSession: 2, MaxAttempts: 1},
+		&PrintJob{ID: 1, Session: "s1", State: JobDone},
+		&PrintJob{ID: 2, Session: "s1", State: JobFailed},
+		&PrintJob{ID: 3, Session: "s2", State: JobQueued})
+	tests := []struct {
+		session string
+		ok      bool
+	}{
+		// a failed print doesn't use up a copy
+		{"s1", true},
+		{"s1", false},
+		{"s2", true},
+		{"s2", false},
+		{"s3", true},
+	}
+	for i, test := range tests {
+		id, err := q.Submit("print.jpg", test.session)
+		if (err == nil) != test.ok {
+			t.Errorf("submit %d for %s: got error %v, want ok %v", i, test.session, err, test.ok)
+		}
+		if err == nil && id != q.nextID-1 {
+			t.Errorf("submit %d got job %d, want %d", i, id, q.nextID-1)
+		}
+	}
+}
+
+func TestPrintQueuePrune(t *testing.T) {
+	var jobs []*PrintJob
+	for i := 1; i <= maxFinishedJobs+20; i++ {
+		state := JobDone
+		switch {
+		case i%10 == 0:
+			state = JobQueued
+		case i%7 == 0:
+			state = JobFailed
+		}
+		jobs = append(jobs, &PrintJob{ID: i, State: state})
+	}
+	q := idleQueue(t, PrintQueueConfig{CopiesPerSession: 1, MaxAttempts: 1}, jobs...)
+	q.prune()
+
+	finished, queued, last := 0, 0, 0
+	for _, job := range q.jobs {
+		if job.ID <= last {
+			t.Fatalf("job %d came after job %d", job.ID, last)
+		}
+		last = job.ID
+		if job.State == JobQueued {
+			queued++
+		} else {
+			finished++
+		}
+	}
+	if finished != maxFinishedJobs || queued != (maxFinishedJobs+20)/10 {
+		t.Errorf("kept %d finished and %d queued jobs, want %d and %d", finished, queued, maxFinishedJobs, (maxFinishedJobs+20)/10)
+	}
+	// the newest are kept
+	if last != maxFinishedJobs+20 || q.jobs[0].ID == 1 {
+		t.Errorf("kept jobs %d to %d", q.jobs[0].ID, last)
+	}
+}
+
+func TestPrintQueueRestore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "printqueue.json")
+	saved := []*PrintJob{
+		{ID: 3, File: "done.jpg", Session: "s1", State: JobDone, Attempts: 1},
+		{ID: 4, File: "failed.jpg", Session: "s2", State: JobFailed, Attempts: 5},
+		// the booth went down while this was printing
+		{ID: 7, File: "printing.jpg", Session: "s3", State: JobPrinting, Attempts: 1},
+		{ID: 5, File: "queued.jpg", Session: "s4", State: JobQueued},
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	printer := &FakePrinter{}
+	q, err := NewPrintQueue(printer, filename, PrintQueueConfig{CopiesPerSession: 1, MaxAttempts: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	waitIdle(t, q)
+	if got, want := printer.Printed(), []string{"printing.jpg", "queued.jpg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("printed %v after a restart, want %v", got, want)
+	}
+	if id, err := q.Submit("new.jpg", "s5"); err != nil || id != 8 {
+		t.Errorf("submitted job %d (%v), want 8", id, err)
+	}
+	waitIdle(t, q)
+
+	// and the queue file has what happened since
+	data, err = os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jobs []PrintJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		t.Fatal(err)
+	}
+	states := make(map[int]JobState)
+	for _, job := range jobs {
+		states[job.ID] = job.State
+	}
+	if want := map[int]JobState{3: JobDone, 4: JobFailed, 7: JobDone, 5: JobDone, 8: JobDone}; !reflect.DeepEqual(states, want) {
+		t.Errorf("saved jobs are %v, want %v", states, want)
+	}
+}
+
+func TestPrintQueueBadFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "printqueue.json")
+	if err := os.WriteFile(filename, []byte(`[{"id": 1, "state": "lost"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPrintQueue(&FakePrinter{}, filename, PrintQueueConfig{}); err == nil {
+		t.Error("loaded a queue with a job in an unknown state")
+	}
+}
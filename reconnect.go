@@ -0,0 +1,145 @@
+package selfies
+
+import (
+	"errors"
+	"image"
+	"log"
+	"time"
+)
+
+// the longest a lost camera waits between attempts to reopen it
+const maxReconnectBackoff = 30 * time.Second
+
+// reconnectingSource wraps a camera that can go away, like a USB webcam
+// that's been bumped.  When it stops producing frames or returns an error it
+// is closed and reopened, backing off between attempts, while ReadFrame
+// reports no new frames.
+type reconnectingSource struct {
+	open    func() (FrameSource, error)
+	src     FrameSource
+	timeout time.Duration
+
+	format        PixelFormat
+	width, height int
+	name          string
+
+	running   bool
+	lastFrame time.Time
+	backoff   time.Duration
+	nextTry   time.Time
+}
+
+// newReconnectingSource opens a source with open, and will use it again to
+// reopen the source if it produces no frames for timeout.
+func newReconnectingSource(open func() (FrameSource, error), timeout time.Duration) (*reconnectingSource, error) {
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+	r := &reconnectingSource{open: open, src: src, timeout: timeout}
+	r.format, r.width, r.height = src.Format()
+	r.name = cameraName(src)
+	return r, nil
+}
+
+// Connected reports whether the camera is currently working.
+func (r *reconnectingSource) Connected() bool {
+	return r.src != nil
+}
+
+// Format returns the format of the current camera, or of the last one if it's gone.
+func (r *reconnectingSource) Format() (PixelFormat, int, int) {
+	return r.format, r.width, r.height
+}
+
+// Name returns the name of the current camera, or of the last one if it's gone.
+func (r *reconnectingSource) Name() string {
+	return r.name
+}
+
+func (r *reconnectingSource) Start() error {
+	r.running = true
+	r.lastFrame = time.Now()
+	if r.src == nil {
+		return nil
+	}
+	return r.src.Start()
+}
+
+func (r *reconnectingSource) Stop() error {
+	r.running = false
+	if r.src == nil {
+		return nil
+	}
+	return r.src.Stop()
+}
+
+func (r *reconnectingSource) ReadFrame() ([]byte, error) {
+	if r.src == nil {
+		if r.running && !time.Now().Before(r.nextTry) {
+			r.reconnect()
+		}
+		return nil, nil
+	}
+	frame, err := r.src.ReadFrame()
+	switch {
+	case err != nil:
+		log.Printf("camera error, reconnecting: %v", err)
+		r.drop()
+	case len(frame) > 0:
+		r.lastFrame = time.Now()
+		return frame, nil
+	case r.running && time.Since(r.lastFrame) > r.timeout:
+		log.Printf("no frames from camera for %v, reconnecting", r.timeout)
+		r.drop()
+	}
+	return nil, nil
+}
+
+// CaptureStill passes through to the camera, if it can take stills.
+func (r *reconnectingSource) CaptureStill() (image.Image, error) {
+	sc, ok := r.src.(StillCapturer)
+	if !ok {
+		return nil, errors.New("camera can't capture stills")
+	}
+	img, err := sc.CaptureStill()
+	// reopening the camera takes a while, don't count that against it
+	r.lastFrame = time.Now()
+	return img, err
+}
+
+func (r *reconnectingSource) drop() {
+	r.src.Close()
+	r.src = nil
+	r.backoff = time.Second
+	r.nextTry = time.Now()
+}
+
+func (r *reconnectingSource) reconnect() {
+	src, err := r.open()
+	if err == nil {
+		if err = src.Start(); err != nil {
+			src.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("failed to reopen camera, trying again in %v: %v", r.backoff, err)
+		r.nextTry = time.Now().Add(r.backoff)
+		if r.backoff *= 2; r.backoff > maxReconnectBackoff {
+			r.backoff = maxReconnectBackoff
+		}
+		return
+	}
+	log.Printf("camera reconnected")
+	r.src = src
+	r.format, r.width, r.height = src.Format()
+	r.name = cameraName(src)
+	r.lastFrame = time.Now()
+}
+
+func (r *reconnectingSource) Close() error {
+	if r.src == nil {
+		return nil
+	}
+	return r.src.Close()
+}
@@ -0,0 +1,104 @@
+package selfies
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SessionRecord is saved next to a session's photos as <session>.json, for
+// tools that sort through the photos after an event.  It's rewritten as the
+// session goes on.
+type SessionRecord struct {
+	ID      string       `json:"id"`
+	Event   string       `json:"event,omitempty"`
+	Started time.Time    `json:"started"`
+	Shots   []ShotRecord `json:"shots"`
+	Clips   []ClipRecord `json:"clips,omitempty"`
+	// Print is the file the print button prints, and Prints how many times it's been printed.
+	Print  string `json:"print,omitempty"`
+	Prints int    `json:"prints"`
+}
+
+// ShotRecord is one photo in a SessionRecord.
+type ShotRecord struct {
+	Shot   int       `json:"shot"` // counting from 1
+	File   string    `json:"file"`
+	Taken  time.Time `json:"taken"`
+	Camera string    `json:"camera,omitempty"`
+	Filter string    `json:"filter,omitempty"`
+}
+
+// ClipRecord is an animation in a SessionRecord.
+type ClipRecord struct {
+	File  string    `json:"file"`
+	Kind  string    `json:"kind"` // "loop", "boomerang" or "video"
+	Taken time.Time `json:"taken"`
+}
+
+// recordName is the name a session's record is saved under.
+func recordName(session string) string {
+	return session + ".json"
+}
+
+// saveRecord queues the current session's record to be written.
+func (s *Selfies) saveRecord() {
+	if s.record != nil {
+		s.writeRecord(s.record)
+	}
+}
+
+// writeRecord queues a session record to be written.
+func (s *Selfies) writeRecord(rec *SessionRecord) {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		s.reportError(err)
+		return
+	}
+	s.recordVersion++
+	name, version := recordName(rec.ID), s.recordVersion
+	err = s.pipeline.Submit(func() func() {
+		if err := s.storage.ReplaceFile(name, version, data); err != nil {
+			return func() { s.reportError(fmt.Errorf("failed to save session record: %v", err)) }
+		}
+		return nil
+	})
+	if err != nil {
+		s.reportError(fmt.Errorf("failed to save session record: %v", err))
+	}
+}
+
+// LoadSessionRecords reads the session records in st, oldest first.  Records
+// that can't be read are logged and skipped.
+func LoadSessionRecords(st *Storage) ([]*SessionRecord, error) {
+	files, err := filepath.Glob(st.Path("*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var records []*SessionRecord
+	for _, file := range files {
+		// session IDs start with the date, which leaves out printqueue.json
+		if name := filepath.Base(file); name[0] < '0' || name[0] > '9' {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("skipping session record: %v", err)
+			continue
+		}
+		rec := &SessionRecord{}
+		if err = json.Unmarshal(data, rec); err != nil || rec.ID == "" ||
+			recordName(rec.ID) != filepath.Base(file) {
+			log.Printf("skipping session record %s: not a session record (%v)", file, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	// IDs are timestamps, so they sort in the order the sessions happened
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
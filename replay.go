@@ -0,0 +1,117 @@
+package selfies
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+type replaySource struct {
+	files    []string
+	width    int
+	height   int
+	interval time.Duration
+	running  bool
+	last     time.Time
+	next     int
+}
+
+// NewReplaySource returns a FrameSource that cycles through the JPEG files in dir,
+// showing each one for a second, scaled and cropped to width x height.
+func NewReplaySource(dir string, width, height int) (FrameSource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &replaySource{width: width &^ 1, height: height, interval: time.Second}
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.IsDir() && (ext == ".jpg" || ext == ".jpeg") {
+			r.files = append(r.files, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(r.files) == 0 {
+		return nil, fmt.Errorf("no jpeg files in %s", dir)
+	}
+	sort.Strings(r.files)
+	return r, nil
+}
+
+func (r *replaySource) Format() (PixelFormat, int, int) {
+	return FormatYUYV, r.width, r.height
+}
+
+func (r *replaySource) Start() error {
+	r.running = true
+	return nil
+}
+
+func (r *replaySource) Stop() error {
+	r.running = false
+	return nil
+}
+
+func (r *replaySource) ReadFrame() ([]byte, error) {
+	if !r.running {
+		return nil, errors.New("replay source is not started")
+	}
+	if !r.last.IsZero() && time.Since(r.last) < r.interval {
+		return nil, nil
+	}
+	r.last = time.Now()
+	filename := r.files[r.next]
+	r.next = (r.next + 1) % len(r.files)
+
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	img, err := jpeg.Decode(fp)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", filename, err)
+	}
+	return imageToYUYV(img, r.width, r.height), nil
+}
+
+func (r *replaySource) Close() error {
+	return nil
+}
+
+// imageToYUYV scales img to cover width x height, crops the center and packs it as YUYV.
+func imageToYUYV(img image.Image, width, height int) []byte {
+	b := img.Bounds()
+	if b.Dx()*height > b.Dy()*width {
+		img = resize.Resize(0, uint(height), img, resize.Bilinear)
+	} else {
+		img = resize.Resize(uint(width), 0, img, resize.Bilinear)
+	}
+	b = img.Bounds()
+	offX := b.Min.X + (b.Dx()-width)/2
+	offY := b.Min.Y + (b.Dy()-height)/2
+
+	frame := make([]byte, width*height*2)
+	for y := 0; y < height; y++ {
+		row := frame[y*width*2:]
+		for x := 0; x < width; x += 2 {
+			r0, g0, b0, _ := img.At(offX+x, offY+y).RGBA()
+			r1, g1, b1, _ := img.At(offX+x+1, offY+y).RGBA()
+			y0, cb0, cr0 := color.RGBToYCbCr(uint8(r0>>8), uint8(g0>>8), uint8(b0>>8))
+			y1, cb1, cr1 := color.RGBToYCbCr(uint8(r1>>8), uint8(g1>>8), uint8(b1>>8))
+			row[x*2] = y0
+			row[x*2+1] = uint8((int(cb0) + int(cb1)) / 2)
+			row[x*2+2] = y1
+			row[x*2+3] = uint8((int(cr0) + int(cr1)) / 2)
+		}
+	}
+	return frame
+}
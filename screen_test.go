@@ -0,0 +1,146 @@
+package selfies
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/ttf"
+)
+
+var (
+	sdlOnce sync.Once
+	sdlErr  error
+)
+
+// boothRun drives a headless booth on the test pattern and a fake clock.
+type boothRun struct {
+	t     *testing.T
+	s     *Selfies
+	fake  *FakeController
+	clock *fakeClock
+}
+
+// newBoothRun starts a headless booth taking shots photos a session, or
+// skips the test if SDL can't start.
+func newBoothRun(t *testing.T, shots int) *boothRun {
+	sdlOnce.Do(func() {
+		os.Setenv("SDL_VIDEODRIVER", "dummy")
+		os.Setenv("SDL_AUDIODRIVER", "dummy")
+		if sdlErr = sdl.Init(sdl.INIT_VIDEO); sdlErr == nil {
+			sdlErr = ttf.Init()
+		}
+	})
+	if sdlErr != nil {
+		t.Skipf("can't start SDL: %v", sdlErr)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Display = DisplayConfig{Headless: true, Width: 270, Height: 480, Layout: "auto"}
+	cfg.Camera.Device, cfg.Camera.Width, cfg.Camera.Height = "test", 320, 240
+	cfg.Controller.Port = "fake"
+	cfg.Printer.Type = "fake"
+	cfg.Timing = testTiming
+	cfg.Strip.Shots = shots
+	cfg.Loop = LoopConfig{FPS: 5, Width: 96}
+	cfg.Video = VideoConfig{FPS: 5, Width: 96}
+	cfg.SavePath = t.TempDir()
+	cfg.MinFreeMB = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSelfies(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	clock := &fakeClock{now: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}
+	s.session = NewSession(cfg.Timing, cfg.Strip.Shots, clock)
+	return &boothRun{t: t, s: s, fake: s.Controller().(*FakeController), clock: clock}
+}
+
+// step lets anything being saved finish, so it shows up on the same step
+// every run, then draws a frame and moves the clock on.
+func (r *boothRun) step() {
+	r.waitSaved()
+	r.s.Step()
+	r.clock.now = r.clock.now.Add(250 * time.Millisecond)
+}
+
+// waitSaved waits for the pipeline to finish what it's been given.
+func (r *boothRun) waitSaved() {
+	for start := time.Now(); r.s.pipeline.Busy(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			r.t.Fatal("the pipeline is stuck")
+		}
+		r.s.pipeline.Finish()
+	}
+}
+
+// until steps until the booth is in state, checking a snapshot of the
+// screen against the golden image for each state it goes through on the
+// way, named after prefix.  It returns how many snapshots it checked.
+func (r *boothRun) until(state State, prefix string, n int) int {
+	r.t.Helper()
+	last := r.s.State()
+	for i := 0; i < 1000; i++ {
+		r.step()
+		if now := r.s.State(); now != last {
+			r.snapshot(fmt.Sprintf("%s-%02d-%s", prefix, n, strings.ToLower(now.String())))
+			n++
+			last = now
+			if now == state {
+				return n
+			}
+		}
+	}
+	r.t.Fatalf("booth stuck in %v", r.s.State())
+	return n
+}
+
+func (r *boothRun) snapshot(name string) {
+	r.t.Helper()
+	img, err := r.s.Snapshot()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	checkGolden(r.t, name, img)
+}
+
+func TestScreenPhotos(t *testing.T) {
+	r := newBoothRun(t, 2)
+	r.step()
+	r.snapshot("screen-photos-00-idle")
+	r.fake.Press(ButtonShoot)
+	// countdown, flash, capture and review for each photo, then back to idle with the print
+	r.until(StateIdle, "screen-photos", 1)
+}
+
+func TestScreenLoop(t *testing.T) {
+	r := newBoothRun(t, 1)
+	r.step()
+	r.fake.Press(ButtonLoop)
+	r.until(StateRecord, "screen-loop", 1)
+	// the loop plays back in real time once it's saved, so the rest isn't compared
+	for r.s.State() != StateIdle {
+		r.step()
+	}
+	r.waitSaved()
+}
+
+func TestScreenVideo(t *testing.T) {
+	r := newBoothRun(t, 1)
+	r.step()
+	r.fake.Press(ButtonVideo)
+	r.until(StateRecord, "screen-video", 1)
+	r.fake.Press(ButtonVideo)
+	r.s.Step()
+	if r.s.State() != StateReview {
+		t.Errorf("stopping the video left the booth %v", r.s.State())
+	}
+	r.waitSaved()
+}
@@ -1,119 +1,153 @@
 package selfies
 
 import (
+	"errors"
 	"fmt"
 	"image"
-	"image/draw"
 	"image/jpeg"
-	"io"
-	"math"
+	"log"
 	"math/rand"
 	"os"
-	"os/exec"
-	"os/user"
 	"path/filepath"
 	"strconv"
 	"time"
 
-	"github.com/blackjack/webcam"
-	"github.com/jacobsa/go-serial/serial"
-	"github.com/nfnt/resize"
+	"github.com/redbo/selfies/convert"
 	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/ttf"
 )
 
-type Selfies struct {
-	screenWidth  int32
-	screenHeight int32
-	renderer     *sdl.Renderer
-	cam          *webcam.Webcam
-	tex          *sdl.Texture
-	texes        []*sdl.Texture
-	printtex     *sdl.Texture
-	printingtex  *sdl.Texture
-	arduino      io.ReadWriteCloser
-	snaps        []*sdl.Texture
-	snapfiles    []string
-	savepath     string
+// photos are converted and saved by this many goroutines, with room for this
+// many to be waiting
+const pipelineWorkers, pipelineQueue = 2, 8
 
-	cleanups []func() error
-}
+// how long an error is shown on screen
+const errorShowTime = 10 * time.Second
 
-var capFormat = webcam.PixelFormat(1448695129) // V4L2_PIX_FMT_YUYV - YUV 4:2:2
-var capWidth int32 = 1280
-var capHeight int32 = 720
+type Selfies struct {
+	screenWidth   int32
+	screenHeight  int32
+	renderer      *sdl.Renderer
+	surface       *sdl.Surface
+	cam           FrameSource
+	tethered      StillCapturer
+	tex           *sdl.Texture
+	texFormat     PixelFormat
+	texWidth      int
+	texHeight     int
+	texes         []*sdl.Texture
+	printtex      *sdl.Texture
+	printingtex   *sdl.Texture
+	lostcamtex    *sdl.Texture
+	controller    Controller
+	printer       Printer
+	printQueue    *PrintQueue
+	snaps         []*sdl.Texture
+	snapfiles     []string
+	sessionID     string
+	shots         []image.Image
+	pipeline      *pipeline
+	developing    int
+	composeWanted bool
+	composing     bool
+	gallery       *gallery
+	filters       []*Filter
+	filterIndex   int
+	filter        *Filter
+	filterBuf     []byte
+	filtertex     *sdl.Texture
+	overlays      []*overlay
+	key           *chromaKey
+	recording     *loopRecording
+	playback      *loopPlayback
+	video         *videoRecording
+	videoPlayback *videoPlayback
+	lastVideo     string
+	record        *SessionRecord
+	recordVersion int
+	printable     string
+	template      *Template
+	storage       *Storage
+	font          *ttf.Font
+	errortex      *sdl.Texture
+	errorUntil    time.Time
+	cfg           *Config
+	session       *Session
+	buttons       <-chan ButtonEvent
+	frame         []byte
+	layout        Layout
+	// closed when the last still has been taken; stills are taken one at a
+	// time, in order
+	stillDone chan struct{}
+	// stillDone while the webcam is taking a still; until then it belongs
+	// to the goroutine taking it
+	camBusy chan struct{}
 
-func initCam(width, height int32) (*webcam.Webcam, error) {
-	cam, err := webcam.Open("/dev/video0")
-	if err != nil {
-		return nil, err
-	}
-	if f, cw, ch, err := cam.SetImageFormat(capFormat, uint32(width), uint32(height)); err != nil {
-		cam.Close()
-		return nil, err
-	} else if f != capFormat || cw != uint32(width) || ch != uint32(height) {
-		cam.Close()
-		return nil, fmt.Errorf("Unknown pixel format %d (%d/%d)", f, cw, ch)
-	}
-	if err = cam.SetBufferCount(1); err != nil {
-		cam.Close()
-		return nil, err
-	}
-	return cam, cam.StartStreaming()
+	cleanups []func() error
 }
 
-func NewSelfies() (*Selfies, error) {
-	s := &Selfies{}
-	window, err := sdl.CreateWindow("SELFIES", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
-		100, 100, sdl.WINDOW_SHOWN|sdl.WINDOW_FULLSCREEN_DESKTOP|sdl.WINDOW_BORDERLESS)
-	s.screenWidth, s.screenHeight = window.GetSize()
+// NewSelfies creates the booth window and opens the hardware described by
+// cfg, which should already have been checked with Validate.
+func NewSelfies(cfg *Config) (*Selfies, error) {
+	s := &Selfies{cfg: cfg}
+	err := s.openDisplay()
 	if err != nil {
 		s.Close()
-		return nil, fmt.Errorf("failed to create renderer: %v", err)
+		return nil, err
 	}
-	s.cleanup(window.Destroy)
+	s.renderer.Clear()
 
-	if s.renderer, err = sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED); err != nil {
+	if s.cam, err = OpenFrameSource(cfg.Camera); err != nil {
 		s.Close()
-		return nil, fmt.Errorf("error creating renderer: %v", err)
+		return nil, fmt.Errorf("failed to initialize camera: %v", err)
 	}
-	s.cleanup(s.renderer.Destroy)
-	s.renderer.Clear()
-
-	if s.cam, err = initCam(capWidth, capHeight); err != nil {
+	s.cleanup(func() error {
+		if s.stillDone != nil {
+			<-s.stillDone
+		}
+		return s.cam.Close()
+	})
+	if err = s.cam.Start(); err != nil {
 		s.Close()
-		return nil, fmt.Errorf("failed to initialize webcam: %v", err)
+		return nil, fmt.Errorf("failed to start camera: %v", err)
 	}
-	s.cleanup(s.cam.Close)
-
-	if s.tex, err = s.renderer.CreateTexture(sdl.PIXELFORMAT_YUY2, sdl.TEXTUREACCESS_STREAMING, int32(capWidth), int32(capHeight)); err != nil {
+	if err = s.openPreview(); err != nil {
 		s.Close()
-		return nil, fmt.Errorf("error creating texture: %v", err)
+		return nil, err
 	}
-	s.cleanup(s.tex.Destroy)
+	s.cleanup(func() error { return s.tex.Destroy() })
+	// thumbnail textures are created as thumbnails come in, and empty places
+	// in the grid aren't drawn
 	s.snaps = make([]*sdl.Texture, 4)
 	s.snapfiles = make([]string, 4)
-	for i := range s.snaps {
-		if s.snaps[i], err = s.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, 430, 287); err != nil {
-			s.Close()
-			return nil, fmt.Errorf("error creating texture: %v", err)
+	s.cleanup(func() error {
+		for _, tex := range s.snaps {
+			if tex != nil {
+				tex.Destroy()
+			}
 		}
-		s.cleanup(s.snaps[i].Destroy)
-	}
-	usr, err := user.Current()
+		return nil
+	})
+	savepath, err := expandHome(cfg.SavePath)
 	if err != nil {
 		s.Close()
-		return nil, fmt.Errorf("failed to get home dir: %v", err)
+		return nil, err
+	}
+	if s.storage, err = NewStorage(savepath, uint64(cfg.MinFreeMB)<<20); err != nil {
+		s.Close()
+		return nil, err
 	}
-	s.savepath = filepath.Join(usr.HomeDir, "selfies", "snaps")
 
-	font, err := makeFont(600)
+	big, err := makeFont(600)
 	if err != nil {
 		s.Close()
 		return nil, fmt.Errorf("failed to read font: %v", err)
 	}
+	// only needed for the countdown digits
+	defer closeFont(big)
 	s.texes = make([]*sdl.Texture, 3)
 	for i := 0; i < 3; i++ {
-		surf, err := font.RenderUTF8Blended(strconv.Itoa(i+1), sdl.Color{R: 255, G: 255, B: 255, A: 255})
+		surf, err := renderBlended(big, strconv.Itoa(i+1), sdl.Color{R: 255, G: 255, B: 255, A: 255})
 		if err != nil {
 			s.Close()
 			return nil, fmt.Errorf("failed to render text: %v", err)
@@ -127,12 +161,19 @@ func NewSelfies() (*Selfies, error) {
 		s.texes[i].SetBlendMode(sdl.BLENDMODE_BLEND)
 	}
 
-	font, err = makeFont(30)
-	if err != nil {
+	if s.font, err = makeFont(30); err != nil {
 		s.Close()
 		return nil, fmt.Errorf("failed to read font: %v", err)
 	}
-	surf, err := font.RenderUTF8Blended("Print", sdl.Color{R: 255, G: 255, B: 0, A: 255})
+	s.cleanup(func() error { closeFont(s.font); return nil })
+	s.cleanup(func() error {
+		if s.errortex != nil {
+			return s.errortex.Destroy()
+		}
+		return nil
+	})
+	font := s.font
+	surf, err := renderBlended(font, "Print", sdl.Color{R: 255, G: 255, B: 0, A: 255})
 	if err != nil {
 		s.Close()
 		return nil, fmt.Errorf("failed to render text: %v", err)
@@ -145,7 +186,7 @@ func NewSelfies() (*Selfies, error) {
 	s.cleanup(s.printtex.Destroy)
 	s.printtex.SetBlendMode(sdl.BLENDMODE_BLEND)
 
-	if surf, err = font.RenderUTF8Blended("Printing", sdl.Color{R: 255, G: 0, B: 0, A: 255}); err != nil {
+	if surf, err = renderBlended(font, "Printing", sdl.Color{R: 255, G: 0, B: 0, A: 255}); err != nil {
 		s.Close()
 		return nil, fmt.Errorf("failed to render text: %v", err)
 	}
@@ -157,18 +198,92 @@ func NewSelfies() (*Selfies, error) {
 	s.cleanup(s.printingtex.Destroy)
 	s.printingtex.SetBlendMode(sdl.BLENDMODE_BLEND)
 
-	if s.arduino, err = serial.Open(serial.OpenOptions{
-		PortName:        "/dev/ttyUSB0",
-		BaudRate:        9600,
-		DataBits:        8,
-		StopBits:        1,
-		MinimumReadSize: 1,
-	}); err != nil {
+	if surf, err = renderBlended(font, "Camera reconnecting...", sdl.Color{R: 255, G: 255, B: 255, A: 255}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to render text: %v", err)
+	}
+	defer surf.Free()
+	if s.lostcamtex, err = s.renderer.CreateTextureFromSurface(surf); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to create texture from surface: %v", err)
+	}
+	s.cleanup(s.lostcamtex.Destroy)
+	s.lostcamtex.SetBlendMode(sdl.BLENDMODE_BLEND)
+
+	if s.tethered, err = NewTetheredCamera(cfg.Tethered); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to set up tethered camera: %v", err)
+	}
+
+	if s.printer, err = NewPrinter(cfg.Printer); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.printQueue, err = NewPrintQueue(s.printer, s.storage.Path("printqueue.json"), cfg.PrintQueue); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to load print queue: %v", err)
+	}
+	s.cleanup(s.printQueue.Close)
+
+	s.pipeline = newPipeline(pipelineWorkers, pipelineQueue)
+	s.cleanup(s.pipeline.Close)
+	s.cleanup(func() error { s.closeGallery(); return nil })
+	s.cleanup(func() error { s.stopPlayback(); return nil })
+	// the pipeline waits for a video being recorded to finish
+	s.cleanup(func() error { s.finishVideo(); return nil })
+
+	for _, name := range cfg.Filters {
+		f, err := FindFilter(name)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.filters = append(s.filters, f)
+	}
+	s.cleanup(func() error {
+		if s.filtertex != nil {
+			return s.filtertex.Destroy()
+		}
+		return nil
+	})
+	s.setFilter(0)
+
+	if s.key, err = newChromaKey(cfg.ChromaKey); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	for _, oc := range cfg.Overlays {
+		o, err := loadOverlay(s.renderer, oc)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.cleanup(o.tex.Destroy)
+		s.overlays = append(s.overlays, o)
+	}
+
+	if cfg.Template != "" {
+		if s.template, err = LoadTemplate(cfg.Template); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to load print template: %v", err)
+		}
+	}
+
+	if s.controller, err = OpenController(cfg.Controller.Port, cfg.Controller.BaudRate); err != nil {
 		s.Close()
-		return nil, fmt.Errorf("serial.Open: %v", err)
+		return nil, fmt.Errorf("failed to open controller: %v", err)
+	}
+	s.cleanup(s.controller.Close)
+	s.buttons = s.controller.Buttons()
+	s.session = NewSession(cfg.Timing, cfg.Strip.Shots, nil)
+	if err = s.restore(); err != nil {
+		log.Printf("failed to restore earlier photos: %v", err)
+	}
+	if err = s.storage.CheckFree(); err != nil {
+		s.reportError(err)
 	}
-	s.cleanup(s.arduino.Close)
-	s.arduino.Write([]byte{'R', '\r', '\n'}) // send a reset
 
 	return s, nil
 }
@@ -177,173 +292,608 @@ func (s *Selfies) cleanup(f func() error) {
 	s.cleanups = append(s.cleanups, f)
 }
 
+// Close releases everything NewSelfies set up, in the reverse order it was set up.
 func (s *Selfies) Close() {
-	for _, c := range s.cleanups {
-		c()
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
 	}
 }
 
-func frameToImage(frame []byte, width int, height int) image.Image {
-	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio422)
-	for i := 0; i < int(capWidth*capHeight); i++ {
-		img.Y[i] = frame[i*2]
-		if i%2 == 0 {
-			img.Cb[i/2] = frame[i*2+1]
-		} else {
-			img.Cr[i/2] = frame[i*2+1]
-		}
+// openPreview creates the preview texture and lays out the screen for the
+// camera's current format, replacing any previous texture.
+func (s *Selfies) openPreview() error {
+	format, width, height := s.cam.Format()
+	layout, err := ComputeLayout(s.cfg.Display.Layout, s.screenWidth, s.screenHeight, width, height)
+	if err != nil {
+		return err
 	}
-	cropped := image.NewRGBA(image.Rect(0, 0, 1080, 720))
-	if height != 720 {
-		resized := resize.Resize(0, 720, img, resize.Bicubic)
-		draw.Draw(cropped, cropped.Bounds(), resized, image.Point{(resized.Bounds().Dx() - 1080) / 2, 0}, draw.Over)
-	} else {
-		draw.Draw(cropped, cropped.Bounds(), img, image.Point{(img.Bounds().Dx() - 1080) / 2, 0}, draw.Over)
+	tex, err := s.renderer.CreateTexture(previewTextureFormat(format), sdl.TEXTUREACCESS_STREAMING, int32(width), int32(height))
+	if err != nil {
+		return fmt.Errorf("error creating texture: %v", err)
+	}
+	if s.tex != nil {
+		s.tex.Destroy()
 	}
-	return cropped
+	s.tex, s.texFormat, s.texWidth, s.texHeight = tex, format, width, height
+	s.layout = layout
+	s.frame = nil
+	return nil
 }
 
-func saveImage(img image.Image, filename string) {
-	if fp, err := os.Create(filename); err == nil {
-		jpeg.Encode(fp, img, &jpeg.Options{Quality: 95})
-		fp.Sync()
-		fp.Close()
+// renderText renders a line of text in the small font to a texture.
+func (s *Selfies) renderText(text string, color sdl.Color) (*sdl.Texture, error) {
+	surf, err := renderBlended(s.font, text, color)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render text: %v", err)
+	}
+	defer surf.Free()
+	tex, err := s.renderer.CreateTextureFromSurface(surf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create texture from surface: %v", err)
 	}
+	tex.SetBlendMode(sdl.BLENDMODE_BLEND)
+	return tex, nil
 }
 
-func printFile(filename string) {
-	exec.Command("/usr/bin/obexftp", "--nopath", "--noconn", "--uuid", "none",
-		"--bluetooth", "C4:30:18:19:C6:3D", "--channel", "4", "-p", filename).Run()
+// reportError logs err and shows it at the bottom of the screen for a while.
+func (s *Selfies) reportError(err error) {
+	log.Print(err)
+	tex, rerr := s.renderText(err.Error(), sdl.Color{R: 255, G: 64, B: 64, A: 255})
+	if rerr != nil {
+		log.Printf("failed to render error: %v", rerr)
+		return
+	}
+	if s.errortex != nil {
+		s.errortex.Destroy()
+	}
+	s.errortex = tex
+	s.errorUntil = time.Now().Add(errorShowTime)
 }
 
-func (s *Selfies) drawCountdown(buttonPressed time.Time) {
-	var tex *sdl.Texture
-	if time.Since(buttonPressed) > time.Millisecond*3000 {
-		tex = s.texes[0]
-	} else if time.Since(buttonPressed) > time.Millisecond*1500 {
-		tex = s.texes[1]
-	} else {
-		tex = s.texes[2]
+// drawError draws the last error from reportError, if it's recent.
+func (s *Selfies) drawError() {
+	if s.errortex == nil || time.Now().After(s.errorUntil) {
+		return
+	}
+	_, _, texWidth, texHeight, _ := s.errortex.Query()
+	dst := sdl.Rect{X: (s.screenWidth - texWidth) / 2, Y: s.screenHeight - 2*texHeight, W: texWidth, H: texHeight}
+	if dst.X < 0 {
+		// too long to fit, so show the start of it
+		dst.X = 0
 	}
+	s.renderer.SetDrawColor(0, 0, 0, 255)
+	s.renderer.FillRect(&sdl.Rect{X: 0, Y: dst.Y - texHeight/2, W: s.screenWidth, H: 2 * texHeight})
+	s.renderer.Copy(s.errortex, &sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight}, &dst)
+}
+
+func (s *Selfies) setRelay(n int, on bool) {
+	if err := s.controller.SetRelay(n, on); err != nil {
+		log.Printf("failed to set relay %d: %v", n, err)
+	}
+}
+
+func (s *Selfies) resetRelays() {
+	if err := s.controller.ResetRelays(); err != nil {
+		log.Printf("failed to reset relays: %v", err)
+	}
+}
+
+func (s *Selfies) drawCountdown(digit int) {
+	tex := s.texes[digit-1]
 	_, _, texWidth, texHeight, _ := tex.Query()
 	s.renderer.Copy(tex,
 		&sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight},
 		&sdl.Rect{X: (s.screenWidth - texWidth) / 2, Y: (s.screenHeight - texHeight) / 2, W: texWidth, H: texHeight})
 }
 
-func (s *Selfies) Run() {
-	buttonPress := make(chan byte)
+// capture takes a photo and hands it to the pipeline to be converted, saved
+// and shown in the thumbnail grid.  A still from the tethered camera, or else
+// the webcam at full resolution, is started here, while the lights are still
+// on, and taken on its own goroutine.  If neither is available or they fail,
+// the photo is cut from the preview frame.
+func (s *Selfies) capture(frame []byte, shot int) {
+	if shot == 0 {
+		s.startSession()
+	}
+	taken := time.Now()
+	// the frame is overwritten by the next ReadFrame, and unmapped if the
+	// camera is reopened to take a still
+	frame = append([]byte(nil), frame...)
+	s.frame = frame
+	session, camName := s.sessionID, cameraName(s.cam)
+	var still <-chan stillResult
+	if s.tethered != nil {
+		still = s.takeStill(s.tethered, false)
+	} else if sc, ok := s.cam.(StillCapturer); ok && s.cfg.Camera.Stills != "preview" {
+		if s.camBusy != nil {
+			log.Printf("camera is still taking the last still, using preview frame")
+		} else {
+			still = s.takeStill(sc, true)
+		}
+	}
+	key, filter, overlays := s.key, s.filter, s.overlays
+	format, width, height := s.texFormat, s.texWidth, s.texHeight
+	thumbWidth, thumbHeight := int(s.layout.ThumbWidth), int(s.layout.ThumbHeight)
+	err := s.pipeline.Submit(func() func() {
+		photo, camera, err := developPhoto(still, frame, format, width, height)
+		if err != nil {
+			log.Printf("BAD FRAME: %v", err)
+			return func() { s.developed(session, shot, nil, nil, nil) }
+		}
+		if camera == "" {
+			camera = camName
+		}
+		if key != nil {
+			key.RGBA(photo)
+		}
+		filter.RGBA(photo)
+		burnOverlays(photo, overlays)
+		meta := &PhotoMeta{Taken: taken, Camera: camera, Event: s.cfg.Event}
+		filename, err := s.storage.SaveJPEG(PhotoName(session, shot), photo, meta)
+		if err != nil {
+			return func() {
+				s.reportError(fmt.Errorf("failed to save photo: %v", err))
+				s.developed(session, shot, nil, nil, nil)
+			}
+		}
+		thumb, err := convert.Image(photo, photo.Bounds(), thumbWidth, thumbHeight)
+		if err != nil {
+			log.Printf("failed to make thumbnail: %v", err)
+		}
+		rec := &ShotRecord{Shot: shot + 1, File: filepath.Base(filename), Taken: taken, Camera: camera, Filter: filter.Name}
+		return func() { s.developed(session, shot, photo, thumb, rec) }
+	})
+	if err != nil {
+		log.Printf("dropping shot %d: %v", shot+1, err)
+		return
+	}
+	s.developing++
+}
 
+// stillResult is a still taken off the render loop, and the name of the
+// camera that took it if it wasn't the webcam.
+type stillResult struct {
+	img    image.Image
+	camera string
+	err    error
+}
+
+// takeStill takes a still with sc on a goroutine of its own, after any
+// still before it, so the render loop keeps drawing while a tethered camera
+// is triggered or the webcam switches resolution and back.  If sc is the
+// webcam, the render loop leaves it alone until it's done.  Otherwise the
+// camera's name is asked for once it's taken the still, since a tethered
+// camera only knows its model from the photos it takes.
+func (s *Selfies) takeStill(sc StillCapturer, webcam bool) <-chan stillResult {
+	prev, done := s.stillDone, make(chan struct{})
+	out := make(chan stillResult, 1)
 	go func() {
-		start := time.Now()
-		b := make([]byte, 1)
-		for true {
-			s.arduino.Read(b)
-			if b[0] > ' ' && time.Since(start) > 5*time.Second { // ignore button presses for first few seconds
-				buttonPress <- b[0]
-			}
+		defer close(done)
+		if prev != nil {
+			<-prev
 		}
+		img, err := sc.CaptureStill()
+		var camera string
+		if !webcam {
+			camera = cameraName(sc)
+		}
+		out <- stillResult{img, camera, err}
 	}()
+	s.stillDone = done
+	if webcam {
+		s.camBusy = done
+	}
+	return out
+}
 
-	buttonPressed := time.Time{}
-	printCooldown := time.Time{}
-	var lights, focus bool
-	var frame []byte
-	var printnotify = make(chan bool)
-	var printing bool
+// startSession starts a new set of photos, or a loop, with its own record.
+func (s *Selfies) startSession() {
+	s.sessionID = newSessionID()
+	s.shots = make([]image.Image, s.session.Shots())
+	s.developing = 0
+	s.record = &SessionRecord{ID: s.sessionID, Event: s.cfg.Event, Started: time.Now()}
+	s.saveRecord()
+	s.stopPlayback()
+}
 
-	for framecount := 0; ; framecount++ {
-		select {
-		case button := <-buttonPress:
-			if button == '2' {
-				s.arduino.Write([]byte{'R', '\r', '\n'})
-				buttonPressed = time.Now()
-			} else if button == '3' && s.snapfiles[0] != "" && time.Since(printCooldown) > time.Second*30 {
-				printCooldown = time.Now()
-				go func() {
-					printnotify <- true
-					printFile(s.snapfiles[0])
-					printnotify <- false
-				}()
-			}
-		case printing = <-printnotify:
-		default:
+// developPhoto waits for the still being taken for the photo, if there is
+// one, or else uses the preview frame.  The photo is cropped to 3:2.  If it
+// came from the tethered camera, that camera's name is returned too.
+func developPhoto(stills <-chan stillResult, frame []byte, format PixelFormat, width, height int) (*image.RGBA, string, error) {
+	var camera string
+	var still image.Image
+	if stills != nil {
+		if r := <-stills; r.err != nil {
+			log.Printf("failed to capture still, using preview frame: %v", r.err)
+		} else {
+			still, camera = r.img, r.camera
 		}
-		s.renderer.Clear()
-		for {
-			if f, _ := s.cam.ReadFrame(); f != nil && len(f) != 0 {
-				frame = f
-				s.tex.Update(&sdl.Rect{X: 0, Y: 0, W: int32(capWidth), H: int32(720)}, frame, 2*int(capHeight))
-			} else {
-				break
-			}
+	}
+	if still != nil {
+		crop := convert.CenterCrop(still.Bounds(), photoAspectW, photoAspectH)
+		photo, err := convert.Image(still, crop, crop.Dx(), crop.Dy())
+		return photo, camera, err
+	}
+	if len(frame) == 0 {
+		return nil, "", errors.New("no preview frame")
+	}
+	crop := convert.CenterCrop(image.Rect(0, 0, width, height), photoAspectW, photoAspectH)
+	photo, err := convertFrame(frame, format, width, height, crop, crop.Dx(), crop.Dy())
+	return photo, "", err
+}
+
+// developed is called back on the render loop when a shot has been saved, or
+// has failed if photo is nil.  The photo is rotated into the thumbnail grid,
+// and becomes part of its session's print if that session is still going.
+func (s *Selfies) developed(session string, shot int, photo image.Image, thumb *image.RGBA, rec *ShotRecord) {
+	var filename string
+	if rec != nil {
+		filename = s.storage.Path(rec.File)
+	}
+	if thumb != nil {
+		s.pushThumb(thumb, filename)
+	}
+	if session != s.sessionID {
+		return
+	}
+	s.developing--
+	if photo != nil {
+		s.shots[shot] = photo
+		s.printable = filename
+		s.record.Shots = append(s.record.Shots, *rec)
+		s.saveRecord()
+	}
+	s.maybeCompose()
+}
+
+// pushThumb rotates a thumbnail into the first place in the thumbnail grid.
+// filename is the photo it's of, or "" for a loop or video.
+func (s *Selfies) pushThumb(thumb *image.RGBA, filename string) {
+	snapWidth, snapHeight := s.layout.ThumbWidth, s.layout.ThumbHeight
+	s.snaps[0], s.snaps[1], s.snaps[2], s.snaps[3] = s.snaps[3], s.snaps[0], s.snaps[1], s.snaps[2]
+	s.snapfiles[0], s.snapfiles[1], s.snapfiles[2], s.snapfiles[3] = filename, s.snapfiles[0], s.snapfiles[1], s.snapfiles[2]
+	if s.snaps[0] == nil {
+		tex, err := s.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, snapWidth, snapHeight)
+		if err != nil {
+			log.Printf("error creating texture: %v", err)
+			return
+		}
+		s.snaps[0] = tex
+	}
+	s.snaps[0].Update(&sdl.Rect{X: 0, Y: 0, W: snapWidth, H: snapHeight}, thumb.Pix, thumb.Stride)
+}
+
+// restore fills the thumbnail grid with the latest photos from earlier runs,
+// and makes the last session's print printable again.
+func (s *Selfies) restore() error {
+	records, err := LoadSessionRecords(s.storage)
+	if err != nil || len(records) == 0 {
+		return err
+	}
+	var files []string
+	for i := len(records) - 1; i >= 0 && len(files) < len(s.snaps); i-- {
+		shots := records[i].Shots
+		for j := len(shots) - 1; j >= 0 && len(files) < len(s.snaps); j-- {
+			files = append(files, s.storage.Path(shots[j].File))
+		}
+	}
+	// oldest first, so the newest ends up first in the grid
+	for i := len(files) - 1; i >= 0; i-- {
+		thumb, err := loadThumb(files[i], int(s.layout.ThumbWidth), int(s.layout.ThumbHeight))
+		if err != nil {
+			log.Printf("not restoring photo: %v", err)
+			continue
 		}
-		snapWidth := int32((s.screenWidth - 40) / 2)
-		snapHeight := int32(int(math.Round((float64(snapWidth) / 3) * 2)))
-		if s.snapfiles[0] != "" {
-			var tex *sdl.Texture
-			if printing {
-				s.renderer.SetDrawColor(uint8(rand.Int()%255), uint8(rand.Int()%255), uint8(rand.Int()%255), 255)
-				tex = s.printingtex
-			} else {
-				s.renderer.SetDrawColor(255, 255, 0, 255)
-				tex = s.printtex
+		s.pushThumb(thumb, files[i])
+	}
+
+	for i := len(records) - 1; i >= 0 && s.lastVideo == ""; i-- {
+		for _, clip := range records[i].Clips {
+			if clip.Kind == "video" {
+				s.lastVideo = s.storage.Path(clip.File)
 			}
-			_, _, texWidth, texHeight, _ := tex.Query()
-			s.renderer.FillRect(&sdl.Rect{X: 0, Y: 800, W: snapWidth, H: snapHeight})
-			s.renderer.Copy(tex,
-				&sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight},
-				&sdl.Rect{X: (snapWidth - texWidth) / 2, Y: (800 - texHeight), W: texWidth, H: texHeight})
-			s.renderer.Copy(s.snaps[0], &sdl.Rect{X: 2, Y: 2, W: snapWidth, H: snapHeight},
-				&sdl.Rect{X: 2, Y: 802, W: 426, H: 283})
-		}
-		s.renderer.SetDrawColor(0, 0, 0, 255)
-		s.renderer.Copy(s.tex, &sdl.Rect{X: 0, Y: 0, W: capWidth, H: capHeight},
-			&sdl.Rect{X: -90, Y: 0, W: 1080, H: 600})
-		s.renderer.Copy(s.snaps[1], &sdl.Rect{X: 0, Y: 0, W: snapWidth, H: snapHeight},
-			&sdl.Rect{X: 470, Y: 800, W: snapWidth, H: snapHeight})
-		s.renderer.Copy(s.snaps[2], &sdl.Rect{X: 0, Y: 0, W: snapWidth, H: snapHeight},
-			&sdl.Rect{X: 0, Y: 1237, W: snapWidth, H: snapHeight})
-		s.renderer.Copy(s.snaps[3], &sdl.Rect{X: 0, Y: 0, W: snapWidth, H: snapHeight},
-			&sdl.Rect{X: 470, Y: 1237, W: snapWidth, H: snapHeight})
-
-		if !buttonPressed.IsZero() {
-			if !focus && time.Since(buttonPressed) > time.Millisecond*4000 { // turn on focus lock
-				focus = true
-				s.arduino.Write([]byte{'A', '\r', '\n'})
+		}
+	}
+
+	last := records[len(records)-1]
+	s.sessionID, s.record = last.ID, last
+	if last.Print != "" {
+		s.printable = s.storage.Path(last.Print)
+	} else if len(last.Shots) > 0 {
+		s.printable = s.storage.Path(last.Shots[len(last.Shots)-1].File)
+	}
+	if _, err := os.Stat(s.printable); err != nil {
+		s.printable = ""
+	}
+	log.Printf("restored %d photos, last session %s", len(files), last.ID)
+	return nil
+}
+
+// loadThumb reads a saved photo and scales it to w x h.
+func loadThumb(filename string, w, h int) (*image.RGBA, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	img, err := jpeg.Decode(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return convert.Image(img, convert.CenterCrop(img.Bounds(), w, h), w, h)
+}
+
+// maybeCompose starts putting the print together once the session is over
+// and all its shots have been developed.
+func (s *Selfies) maybeCompose() {
+	if !s.composeWanted || s.developing > 0 {
+		return
+	}
+	s.composeWanted = false
+	var shots []image.Image
+	for _, shot := range s.shots {
+		if shot != nil {
+			shots = append(shots, shot)
+		}
+	}
+	session := s.sessionID
+	err := s.pipeline.Submit(func() func() {
+		filename, err := s.compose(session, shots)
+		return func() {
+			s.composing = false
+			if err != nil {
+				s.reportError(fmt.Errorf("failed to make print: %v", err))
+			} else if filename != "" && session == s.sessionID {
+				s.printable = filename
+				s.record.Print = filepath.Base(filename)
+				s.saveRecord()
 			}
-			if !lights && time.Since(buttonPressed) > time.Millisecond*3500 { // turn on lights
-				lights = true
-				s.arduino.Write([]byte{'B', '\r', '\n'})
+		}
+	})
+	if err != nil {
+		log.Printf("not making a print: %v", err)
+		return
+	}
+	s.composing = true
+}
+
+// compose puts a session's shots together on the print template, or on a
+// strip if there isn't one, and returns the file it saved them in, or "" if
+// there's nothing to print.  The result is what the print button prints.  It
+// runs in the pipeline, so only uses settings that don't change.
+func (s *Selfies) compose(session string, shots []image.Image) (string, error) {
+	meta := &PhotoMeta{Taken: time.Now(), Event: s.cfg.Event}
+	if s.template != nil && len(shots) > 0 {
+		page, err := s.template.Render(shots)
+		if err != nil {
+			return "", err
+		}
+		return s.storage.SaveJPEG(session+"-print.jpg", page, meta)
+	}
+	if len(shots) < 2 {
+		return "", nil
+	}
+	return s.storage.SaveJPEG(session+"-strip.jpg", composeStrip(shots, s.cfg.Strip.Columns, s.cfg.Strip.Margin), meta)
+}
+
+// perform carries out the actions returned by the session.
+func (s *Selfies) perform(actions []Action) {
+	for _, a := range actions {
+		switch a.Type {
+		case ActionSetRelay:
+			s.setRelay(a.Relay, a.On)
+		case ActionResetRelays:
+			s.resetRelays()
+		case ActionFlash:
+			s.renderer.SetDrawColor(255, 255, 255, 255)
+			s.renderer.Clear()
+			s.renderer.Present()
+			s.renderer.SetDrawColor(0, 0, 0, 255)
+		case ActionCapture:
+			s.capture(s.frame, a.Shot)
+		case ActionCompose:
+			s.composeWanted = true
+			s.maybeCompose()
+		case ActionStartLoop:
+			s.startLoop()
+		case ActionFinishLoop:
+			s.finishLoop()
+		case ActionStartVideo:
+			s.startVideo()
+		case ActionFinishVideo:
+			s.finishVideo()
+		}
+	}
+}
+
+// State returns what stage of taking photos the booth is in.
+func (s *Selfies) State() State {
+	return s.session.State()
+}
+
+// Saving reports whether any photos or prints are still being saved.
+func (s *Selfies) Saving() bool {
+	return s.pipeline.Busy()
+}
+
+// Controller returns the booth's controller, e.g. to press buttons on a FakeController.
+func (s *Selfies) Controller() Controller {
+	return s.controller
+}
+
+// Run runs the booth forever.
+func (s *Selfies) Run() {
+	for {
+		s.Step()
+	}
+}
+
+// setFilter switches to configured filter i, which is named in the corner of
+// the live view unless it's "none".
+func (s *Selfies) setFilter(i int) {
+	s.filterIndex, s.filter = i, s.filters[i]
+	if s.filtertex != nil {
+		s.filtertex.Destroy()
+		s.filtertex = nil
+	}
+	if s.filter.Name == "none" {
+		return
+	}
+	tex, err := s.renderText(s.filter.Name, sdl.Color{R: 255, G: 255, B: 255, A: 255})
+	if err != nil {
+		log.Printf("failed to render filter name: %v", err)
+		return
+	}
+	s.filtertex = tex
+}
+
+// drawBooth draws the live view, or the photo just taken, and the thumbnail grid.
+func (s *Selfies) drawBooth() {
+	l := &s.layout
+	thumb := l.Thumbs[0]
+	if s.printable != "" {
+		var tex *sdl.Texture
+		if s.printQueue.Busy() && s.cfg.Display.Headless {
+			// snapshots come out the same every time
+			s.renderer.SetDrawColor(255, 0, 0, 255)
+			tex = s.printingtex
+		} else if s.printQueue.Busy() {
+			s.renderer.SetDrawColor(uint8(rand.Int()%255), uint8(rand.Int()%255), uint8(rand.Int()%255), 255)
+			tex = s.printingtex
+		} else {
+			s.renderer.SetDrawColor(255, 255, 0, 255)
+			tex = s.printtex
+		}
+		_, _, texWidth, texHeight, _ := tex.Query()
+		s.renderer.FillRect(&thumb)
+		s.renderer.Copy(tex,
+			&sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight},
+			&sdl.Rect{X: thumb.X + (thumb.W-texWidth)/2, Y: thumb.Y - texHeight, W: texWidth, H: texHeight})
+	}
+	if s.snaps[0] != nil {
+		s.renderer.Copy(s.snaps[0], &sdl.Rect{X: 0, Y: 0, W: l.ThumbWidth, H: l.ThumbHeight},
+			&sdl.Rect{X: thumb.X + 2, Y: thumb.Y + 2, W: thumb.W - 4, H: thumb.H - 4})
+	}
+	s.renderer.SetDrawColor(0, 0, 0, 255)
+	// until the photo has been developed, keep showing the live view; loops
+	// and videos play back over it once they're saved
+	if s.session.State() == StateReview && s.session.Mode() == ModePhotos && s.developing == 0 && s.snaps[0] != nil {
+		s.renderer.Copy(s.snaps[0], &sdl.Rect{X: 0, Y: 0, W: l.ThumbWidth, H: l.ThumbHeight}, &l.Review)
+	} else if c, ok := s.cam.(interface{ Connected() bool }); ok && s.camBusy == nil && !c.Connected() {
+		_, _, texWidth, texHeight, _ := s.lostcamtex.Query()
+		s.renderer.FillRect(&l.Preview)
+		s.renderer.Copy(s.lostcamtex,
+			&sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight},
+			&sdl.Rect{X: l.Preview.X + (l.Preview.W-texWidth)/2, Y: l.Preview.Y + (l.Preview.H-texHeight)/2, W: texWidth, H: texHeight})
+	} else {
+		s.filter.drawPreview(s.renderer, s.tex, &l.PreviewSrc, &l.Preview)
+		s.drawOverlays()
+		if s.filtertex != nil {
+			_, _, texWidth, texHeight, _ := s.filtertex.Query()
+			s.renderer.Copy(s.filtertex, &sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight},
+				&sdl.Rect{X: l.Preview.X + texHeight/2, Y: l.Preview.Y + texHeight/2, W: texWidth, H: texHeight})
+		}
+	}
+	if state := s.session.State(); state == StateIdle || state == StateReview {
+		if s.playback != nil {
+			s.drawPlayback()
+		}
+		if s.videoPlayback != nil {
+			s.drawVideoPlayback()
+		}
+	} else if s.video != nil {
+		s.drawVideoTimer()
+	}
+	for i := 1; i < len(s.snaps); i++ {
+		if s.snaps[i] != nil {
+			s.renderer.Copy(s.snaps[i], &sdl.Rect{X: 0, Y: 0, W: l.ThumbWidth, H: l.ThumbHeight}, &l.Thumbs[i])
+		}
+	}
+}
+
+// Step handles any pending button press, reads the camera and draws one frame.
+func (s *Selfies) Step() {
+	s.pipeline.Finish()
+	select {
+	case ev, ok := <-s.buttons:
+		if !ok {
+			log.Printf("controller went away, ignoring buttons")
+			s.buttons = nil
+		} else if s.gallery != nil {
+			s.galleryButton(ev.Button)
+		} else if ev.Button == ButtonShoot {
+			s.perform(s.session.Handle(EventShoot))
+		} else if ev.Button == ButtonLoop {
+			s.perform(s.session.Handle(EventLoop))
+		} else if ev.Button == ButtonVideo && s.session.State() == StateRecord {
+			s.perform(s.session.Handle(EventStop))
+		} else if ev.Button == ButtonVideo {
+			s.perform(s.session.Handle(EventVideo))
+		} else if ev.Button == ButtonReplay && s.lastVideo != "" && s.session.State() == StateIdle {
+			s.playVideo(s.lastVideo)
+		} else if ev.Button == ButtonPrint && s.printable != "" && s.session.State() == StateIdle && !s.composeWanted && !s.composing {
+			// the print queue limits how many copies each session gets
+			if _, err := s.printQueue.Submit(s.printable, s.sessionID); err != nil {
+				log.Printf("not printing %s: %v", s.printable, err)
+			} else if s.record != nil {
+				s.record.Prints++
+				s.saveRecord()
 			}
-			if time.Since(buttonPressed) > time.Millisecond*4500 {
-				if frame != nil && len(frame) != 0 {
-					// flash a white screen
-					s.renderer.SetDrawColor(255, 255, 255, 255)
-					s.renderer.Clear()
-					s.renderer.Present()
-					s.renderer.SetDrawColor(0, 0, 0, 255)
-					filename := filepath.Join(s.savepath, fmt.Sprintf("%d.jpg", time.Now().Unix()))
-					cropped := frameToImage(frame, int(capWidth), int(capHeight))
-					saveImage(cropped, filename)
-					snap := image.NewRGBA(image.Rect(0, 0, int(snapWidth), int(snapHeight)))
-					draw.Draw(snap, snap.Bounds(),
-						resize.Resize(uint(snapWidth), uint(snapHeight), cropped, resize.Bicubic),
-						image.ZP, draw.Over)
-					s.snaps[0], s.snaps[1], s.snaps[2], s.snaps[3] = s.snaps[3], s.snaps[0], s.snaps[1], s.snaps[2]
-					s.snaps[0].Update(&sdl.Rect{X: 0, Y: 0, W: snapWidth, H: snapHeight}, snap.Pix, snap.Stride)
-					s.snapfiles[0], s.snapfiles[1], s.snapfiles[2], s.snapfiles[3] = filename, s.snapfiles[0], s.snapfiles[1], s.snapfiles[2]
-				} else {
-					fmt.Println("BAD FRAME")
-				}
-				s.arduino.Write([]byte{'C', '\r', '\n'}) // trigger shutter release
-				time.Sleep(time.Millisecond * 200)
-				s.arduino.Write([]byte{'R', '\r', '\n'})                 // reset all relays
-				lights, focus, buttonPressed = false, false, time.Time{} // reset state machine
-			} else {
-				s.drawCountdown(buttonPressed)
+		} else if ev.Button == ButtonGallery && s.session.State() == StateIdle {
+			s.openGallery()
+		} else if ev.Button == ButtonFilter && s.session.State() == StateIdle {
+			s.setFilter((s.filterIndex + 1) % len(s.filters))
+		}
+	default:
+	}
+	s.renderer.Clear()
+	if s.camBusy != nil {
+		select {
+		case <-s.camBusy:
+			s.camBusy = nil
+		default:
+		}
+	}
+	fresh := false
+	// the live view holds still while the webcam is taking a still
+	for s.camBusy == nil {
+		if f, _ := s.cam.ReadFrame(); f != nil && len(f) != 0 {
+			s.frame = f
+			fresh = true
+		} else {
+			break
+		}
+	}
+	// a reconnected camera may have come back with a different format
+	if s.camBusy == nil {
+		if format, width, height := s.cam.Format(); format != s.texFormat || width != s.texWidth || height != s.texHeight {
+			if err := s.openPreview(); err != nil {
+				log.Printf("failed to recreate preview: %v", err)
 			}
+			fresh = false
+		}
+	}
+	if fresh {
+		if err := updatePreview(s.tex, s.texFormat, s.frame, s.texWidth, s.texHeight, s.key, s.filter, &s.filterBuf); err != nil {
+			log.Printf("failed to update preview: %v", err)
 		}
-		s.renderer.Present()
+		if s.recording != nil {
+			s.recordFrame()
+		}
+		if s.video != nil {
+			s.recordVideoFrame()
+		}
+	}
+	if s.gallery != nil {
+		s.drawGallery()
+	} else {
+		s.drawBooth()
+	}
+
+	s.perform(s.session.Handle(EventTick))
+	if digit := s.session.CountdownDigit(); digit > 0 {
+		s.drawCountdown(digit)
 	}
+	s.drawError()
+	s.renderer.Present()
 }
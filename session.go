@@ -0,0 +1,246 @@
+package selfies
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock tells the session what time it is, so tests can drive it without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// State is a stage of taking a photo.
+type State int
+
+const (
+	StateIdle      State = iota // showing the live preview, waiting for a button
+	StateCountdown              // counting down to the photo, turning on focus and lights along the way
+	StateFlash                  // flashing the screen and grabbing the frame
+	StateCapture                // holding the camera's shutter release
+	StateReview                 // showing off the photo that was just taken
+	StateRecord                 // recording a loop or a video
+)
+
+var stateNames = []string{"Idle", "Countdown", "Flash", "Capture", "Review", "Record"}
+
+func (s State) String() string {
+	if s >= 0 && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Event is something that happened that the session may need to react to.
+type Event int
+
+const (
+	// EventTick should be sent every frame to let timed transitions happen.
+	EventTick Event = iota
+	// EventShoot is the shoot button being pressed.
+	EventShoot
+	// EventLoop is the loop button being pressed, to record a short animation instead of photos.
+	EventLoop
+	// EventVideo is the video button being pressed, to record a video message instead of photos.
+	EventVideo
+	// EventStop is the video button being pressed again, to finish the video early.
+	EventStop
+)
+
+// ActionType is something the session needs the booth to do.
+type ActionType int
+
+const (
+	ActionSetRelay    ActionType = iota // turn Action.Relay on or off
+	ActionResetRelays                   // turn all relays off
+	ActionFlash                         // flash the screen white
+	ActionCapture                       // save the current frame as shot Action.Shot
+	ActionCompose                       // all shots are taken, put them together on a strip
+	ActionStartLoop                     // start keeping frames for a loop
+	ActionFinishLoop                    // stop keeping frames and save the loop
+	ActionStartVideo                    // start recording a video
+	ActionFinishVideo                   // stop recording and save the video
+)
+
+// Mode is what a session is taking.
+type Mode int
+
+const (
+	ModePhotos Mode = iota
+	ModeLoop
+	ModeVideo
+)
+
+type Action struct {
+	Type  ActionType
+	Relay int
+	On    bool
+	Shot  int
+}
+
+// how long the shutter release relay is held closed
+const shutterHold = 200 * time.Millisecond
+
+// Session is the state machine for taking a set of photos.  Each shot gets
+// its own countdown.  A loop gets one countdown, then records for
+// TimingConfig.Loop, and a video records after its countdown until it's
+// stopped or TimingConfig.Video is up.  It doesn't touch any hardware itself; Handle returns
+// the actions the booth should carry out.
+type Session struct {
+	clock   Clock
+	timing  TimingConfig
+	shots   int
+	shot    int
+	state   State
+	mode    Mode
+	entered time.Time
+	lights  bool
+	focus   bool
+}
+
+// NewSession returns a session that takes the given number of shots per button press.
+func NewSession(timing TimingConfig, shots int, clock Clock) *Session {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	if shots < 1 {
+		shots = 1
+	}
+	return &Session{clock: clock, timing: timing, shots: shots}
+}
+
+func (s *Session) State() State {
+	return s.state
+}
+
+// Shot returns the index of the shot being taken, or the number taken once they're all done.
+func (s *Session) Shot() int {
+	return s.shot
+}
+
+// Mode returns what the current or last session is taking.
+func (s *Session) Mode() Mode {
+	return s.mode
+}
+
+// Shots returns how many shots are taken per button press.
+func (s *Session) Shots() int {
+	return s.shots
+}
+
+// Elapsed returns how long the session has been in its current state.
+func (s *Session) Elapsed() time.Duration {
+	return s.clock.Now().Sub(s.entered)
+}
+
+// CountdownDigit returns the number to show on screen during the countdown:
+// 3, 2, 1, or 0 if the session isn't counting down.
+func (s *Session) CountdownDigit() int {
+	if s.state != StateCountdown {
+		return 0
+	}
+	step := s.timing.Shutter.Duration / 3
+	switch elapsed := s.Elapsed(); {
+	case elapsed > step*2:
+		return 1
+	case elapsed > step:
+		return 2
+	}
+	return 3
+}
+
+func (s *Session) enter(state State) {
+	s.state = state
+	s.entered = s.clock.Now()
+}
+
+func (s *Session) startCountdown() {
+	s.lights, s.focus = false, false
+	s.enter(StateCountdown)
+}
+
+// Handle advances the state machine and returns the actions to carry out, in order.
+func (s *Session) Handle(ev Event) []Action {
+	if ev != EventTick && s.state != StateIdle && !(ev == EventStop && s.state == StateRecord) {
+		return nil
+	}
+	var actions []Action
+	switch s.state {
+	case StateIdle:
+		if ev == EventShoot || ev == EventLoop || ev == EventVideo {
+			s.shot = 0
+			switch ev {
+			case EventShoot:
+				s.mode = ModePhotos
+			case EventLoop:
+				s.mode = ModeLoop
+			case EventVideo:
+				s.mode = ModeVideo
+			}
+			s.startCountdown()
+			actions = append(actions, Action{Type: ActionResetRelays})
+		}
+	case StateCountdown:
+		elapsed := s.Elapsed()
+		if !s.focus && elapsed > s.timing.Focus.Duration { // turn on focus lock
+			s.focus = true
+			actions = append(actions, Action{Type: ActionSetRelay, Relay: RelayFocus, On: true})
+		}
+		if !s.lights && elapsed > s.timing.Lights.Duration { // turn on lights
+			s.lights = true
+			actions = append(actions, Action{Type: ActionSetRelay, Relay: RelayLights, On: true})
+		}
+		if elapsed > s.timing.Shutter.Duration && s.mode == ModeLoop {
+			s.enter(StateRecord)
+			actions = append(actions, Action{Type: ActionStartLoop})
+		} else if elapsed > s.timing.Shutter.Duration && s.mode == ModeVideo {
+			s.enter(StateRecord)
+			actions = append(actions, Action{Type: ActionStartVideo})
+		} else if elapsed > s.timing.Shutter.Duration {
+			s.enter(StateFlash)
+			actions = append(actions, Action{Type: ActionFlash}, Action{Type: ActionCapture, Shot: s.shot})
+		}
+	case StateFlash:
+		s.enter(StateCapture)
+		actions = append(actions, Action{Type: ActionSetRelay, Relay: RelayShutter, On: true}) // trigger shutter release
+	case StateCapture:
+		if s.Elapsed() > shutterHold {
+			s.shot++
+			s.enter(StateReview)
+			actions = append(actions, Action{Type: ActionResetRelays})
+			if s.shot == s.shots {
+				actions = append(actions, Action{Type: ActionCompose})
+			}
+		}
+	case StateRecord:
+		if s.mode == ModeLoop && s.Elapsed() > s.timing.Loop.Duration {
+			s.shot++
+			s.enter(StateReview)
+			actions = append(actions, Action{Type: ActionResetRelays}, Action{Type: ActionFinishLoop})
+		} else if s.mode == ModeVideo && (ev == EventStop || s.Elapsed() > s.timing.Video.Duration) {
+			s.shot++
+			s.enter(StateReview)
+			actions = append(actions, Action{Type: ActionResetRelays}, Action{Type: ActionFinishVideo})
+		}
+	case StateReview:
+		if s.Elapsed() >= s.timing.Review.Duration {
+			if s.mode == ModePhotos && s.shot < s.shots {
+				s.startCountdown()
+			} else {
+				s.enter(StateIdle)
+			}
+		}
+	}
+	return actions
+}
+
+// newSessionID names a new set of shots after the time it was started.
+func newSessionID() string {
+	return time.Now().Format("20060102-150405")
+}
@@ -0,0 +1,215 @@
+package selfies
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+var testTiming = TimingConfig{
+	Focus:   Duration{1 * time.Second},
+	Lights:  Duration{2 * time.Second},
+	Shutter: Duration{3 * time.Second},
+	Review:  Duration{1 * time.Second},
+	Loop:    Duration{2 * time.Second},
+	Video:   Duration{5 * time.Second},
+}
+
+// step is a tick that changed the session's state or asked for something,
+// at a time counted from when the button was pressed.
+type step struct {
+	at      time.Duration
+	state   State
+	actions []Action
+}
+
+// sessionRun drives a session on a fake clock.
+type sessionRun struct {
+	t     *testing.T
+	clock *fakeClock
+	start time.Time
+	s     *Session
+}
+
+func newSessionRun(t *testing.T, shots int) *sessionRun {
+	clock := &fakeClock{now: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}
+	return &sessionRun{t: t, clock: clock, start: clock.now, s: NewSession(testTiming, shots, clock)}
+}
+
+// handle sends ev and returns what happened as a step.
+func (r *sessionRun) handle(ev Event) step {
+	actions := r.s.Handle(ev)
+	return step{r.clock.now.Sub(r.start), r.s.State(), actions}
+}
+
+// tick advances the clock 100ms at a time, until the session is idle or
+// in state until, and returns the ticks that did something.
+func (r *sessionRun) tick(until State) []step {
+	var steps []step
+	for i := 0; i < 1000; i++ {
+		r.clock.now = r.clock.now.Add(100 * time.Millisecond)
+		last := r.s.State()
+		st := r.handle(EventTick)
+		if st.state != last || len(st.actions) > 0 {
+			steps = append(steps, st)
+		}
+		if st.state == StateIdle || st.state == until {
+			return steps
+		}
+	}
+	r.t.Fatalf("session stuck in %v", r.s.State())
+	return nil
+}
+
+func checkSteps(t *testing.T, got, want []step) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got steps:")
+		for _, st := range got {
+			t.Errorf("  %v", st)
+		}
+		t.Errorf("want:")
+		for _, st := range want {
+			t.Errorf("  %v", st)
+		}
+	}
+}
+
+func relay(n int, on bool) Action {
+	return Action{Type: ActionSetRelay, Relay: n, On: on}
+}
+
+// shotSteps is what a photo starting from a countdown at start does.
+func shotSteps(start time.Duration, shot int) []step {
+	ms := func(n int) time.Duration { return start + time.Duration(n)*time.Millisecond }
+	return []step{
+		{ms(1100), StateCountdown, []Action{relay(RelayFocus, true)}},
+		{ms(2100), StateCountdown, []Action{relay(RelayLights, true)}},
+		{ms(3100), StateFlash, []Action{{Type: ActionFlash}, {Type: ActionCapture, Shot: shot}}},
+		{ms(3200), StateCapture, []Action{relay(RelayShutter, true)}},
+		{ms(3500), StateReview, []Action{{Type: ActionResetRelays}}},
+	}
+}
+
+func TestSessionPhotos(t *testing.T) {
+	r := newSessionRun(t, 2)
+	checkSteps(t, []step{r.handle(EventShoot)}, []step{{0, StateCountdown, []Action{{Type: ActionResetRelays}}}})
+	if r.s.Mode() != ModePhotos {
+		t.Errorf("mode is %v, want photos", r.s.Mode())
+	}
+
+	want := shotSteps(0, 0)
+	// the second shot's countdown starts once the first has been reviewed
+	want = append(want, step{4500 * time.Millisecond, StateCountdown, nil})
+	second := shotSteps(4500*time.Millisecond, 1)
+	second[len(second)-1].actions = append(second[len(second)-1].actions, Action{Type: ActionCompose})
+	want = append(want, second...)
+	want = append(want, step{9000 * time.Millisecond, StateIdle, nil})
+	checkSteps(t, r.tick(StateIdle), want)
+	if r.s.Shot() != 2 {
+		t.Errorf("took %d shots, want 2", r.s.Shot())
+	}
+}
+
+func TestSessionIgnoresButtonsWhileBusy(t *testing.T) {
+	r := newSessionRun(t, 1)
+	r.handle(EventShoot)
+	for _, ev := range []Event{EventShoot, EventLoop, EventVideo, EventStop} {
+		if st := r.handle(ev); st.actions != nil || st.state != StateCountdown {
+			t.Errorf("event %v during the countdown did %v", ev, st)
+		}
+	}
+	r.tick(StateReview)
+	if st := r.handle(EventShoot); st.actions != nil || st.state != StateReview {
+		t.Errorf("shoot during review did %v", st)
+	}
+	r.tick(StateIdle)
+	if st := r.handle(EventShoot); st.state != StateCountdown {
+		t.Errorf("shoot once idle left the session %v", st.state)
+	}
+}
+
+func TestSessionCountdownDigit(t *testing.T) {
+	r := newSessionRun(t, 1)
+	if d := r.s.CountdownDigit(); d != 0 {
+		t.Errorf("digit %d while idle", d)
+	}
+	r.handle(EventShoot)
+	for _, want := range []struct {
+		at    time.Duration
+		digit int
+	}{{0, 3}, {999 * time.Millisecond, 3}, {1001 * time.Millisecond, 2}, {2001 * time.Millisecond, 1}} {
+		r.clock.now = r.start.Add(want.at)
+		if d := r.s.CountdownDigit(); d != want.digit {
+			t.Errorf("digit %d at %v, want %d", d, want.at, want.digit)
+		}
+	}
+}
+
+func TestSessionLoop(t *testing.T) {
+	r := newSessionRun(t, 3)
+	checkSteps(t, []step{r.handle(EventLoop)}, []step{{0, StateCountdown, []Action{{Type: ActionResetRelays}}}})
+	if r.s.Mode() != ModeLoop {
+		t.Errorf("mode is %v, want loop", r.s.Mode())
+	}
+	// one countdown however many shots a set of photos has, then a loop
+	// that records for timing.loop
+	checkSteps(t, r.tick(StateIdle), []step{
+		{1100 * time.Millisecond, StateCountdown, []Action{relay(RelayFocus, true)}},
+		{2100 * time.Millisecond, StateCountdown, []Action{relay(RelayLights, true)}},
+		{3100 * time.Millisecond, StateRecord, []Action{{Type: ActionStartLoop}}},
+		{5200 * time.Millisecond, StateReview, []Action{{Type: ActionResetRelays}, {Type: ActionFinishLoop}}},
+		{6200 * time.Millisecond, StateIdle, nil},
+	})
+}
+
+func TestSessionVideoStopped(t *testing.T) {
+	r := newSessionRun(t, 1)
+	r.handle(EventVideo)
+	if r.s.Mode() != ModeVideo {
+		t.Errorf("mode is %v, want video", r.s.Mode())
+	}
+	checkSteps(t, r.tick(StateRecord), []step{
+		{1100 * time.Millisecond, StateCountdown, []Action{relay(RelayFocus, true)}},
+		{2100 * time.Millisecond, StateCountdown, []Action{relay(RelayLights, true)}},
+		{3100 * time.Millisecond, StateRecord, []Action{{Type: ActionStartVideo}}},
+	})
+	r.clock.now = r.clock.now.Add(time.Second)
+	// only stop does anything while recording
+	for _, ev := range []Event{EventShoot, EventLoop, EventVideo} {
+		if st := r.handle(ev); st.actions != nil || st.state != StateRecord {
+			t.Errorf("event %v while recording did %v", ev, st)
+		}
+	}
+	checkSteps(t, []step{r.handle(EventStop)}, []step{
+		{4100 * time.Millisecond, StateReview, []Action{{Type: ActionResetRelays}, {Type: ActionFinishVideo}}},
+	})
+	checkSteps(t, r.tick(StateIdle), []step{{5100 * time.Millisecond, StateIdle, nil}})
+}
+
+func TestSessionVideoTimesOut(t *testing.T) {
+	r := newSessionRun(t, 1)
+	r.handle(EventVideo)
+	r.tick(StateRecord)
+	checkSteps(t, r.tick(StateReview), []step{
+		{8200 * time.Millisecond, StateReview, []Action{{Type: ActionResetRelays}, {Type: ActionFinishVideo}}},
+	})
+	// stop does nothing once the video is done
+	if st := r.handle(EventStop); st.actions != nil {
+		t.Errorf("stop after the video timed out did %v", st)
+	}
+}
+
+func TestNewSessionShots(t *testing.T) {
+	if n := NewSession(testTiming, 0, nil).Shots(); n != 1 {
+		t.Errorf("a session with no shots takes %d", n)
+	}
+}
@@ -0,0 +1,130 @@
+package selfies
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/blackjack/webcam"
+)
+
+// StillCapturer is implemented by frame sources that can take a photo at a
+// higher resolution than their preview frames.
+type StillCapturer interface {
+	// CaptureStill takes a photo and goes back to streaming preview frames.
+	CaptureStill() (image.Image, error)
+}
+
+// frames thrown away after switching resolution, while the camera's auto
+// exposure settles
+const stillWarmupFrames = 3
+
+// seconds to wait for each frame at still resolution
+const stillFrameTimeout = 5
+
+// CaptureStill reopens the camera at its still resolution, grabs a frame and
+// reopens it for preview.  Most webcams won't change resolution while their
+// buffers are mapped, so the device is closed rather than just stopped, and
+// any frame read from it before is gone.  If the preview can't be restored,
+// the camera is left closed for ReadFrame to report, but the still is kept.
+func (v *v4l2Source) CaptureStill() (image.Image, error) {
+	if v.cam == nil {
+		return nil, errors.New("camera is closed")
+	}
+	v.cam.Close()
+	v.cam = nil
+	img, err := v.grabStill()
+	if rerr := v.reopen(); rerr != nil {
+		log.Printf("failed to restore preview: %v", rerr)
+	}
+	return img, err
+}
+
+func (v *v4l2Source) grabStill() (image.Image, error) {
+	cam, err := webcam.Open(v.path)
+	if err != nil {
+		return nil, err
+	}
+	defer cam.Close()
+	width, height := v.cfg.StillWidth, v.cfg.StillHeight
+	if width == 0 || height == 0 {
+		if width, height = largestFrameSize(cam); width == 0 {
+			return nil, errors.New("camera doesn't list its frame sizes")
+		}
+	}
+	format, err := negotiateFormat(cam, width, height, "auto")
+	if err != nil {
+		return nil, err
+	}
+	if err = cam.StartStreaming(); err != nil {
+		return nil, err
+	}
+	var frame []byte
+	for i := 0; i <= stillWarmupFrames; i++ {
+		if err = cam.WaitForFrame(stillFrameTimeout); err != nil {
+			return nil, fmt.Errorf("waiting for %dx%d %v frame: %v", width, height, format, err)
+		}
+		if frame, err = cam.ReadFrame(); err != nil {
+			return nil, err
+		}
+	}
+	// the frame is in the camera's buffer, which goes away with it
+	frame = append([]byte(nil), frame...)
+	img, err := decodeFrame(frame, format, width, height)
+	if err != nil {
+		return nil, err
+	}
+	// JPEGs carry their own size, which may not be what was negotiated
+	if b := img.Bounds(); b.Dx() != width || b.Dy() != height {
+		return nil, fmt.Errorf("asked for a %dx%d still, got %dx%d", width, height, b.Dx(), b.Dy())
+	}
+	log.Printf("captured %dx%d %v still", width, height, format)
+	return img, nil
+}
+
+// reopen opens the camera again with the preview settings it had before.
+func (v *v4l2Source) reopen() error {
+	cam, err := webcam.Open(v.path)
+	if err != nil {
+		return err
+	}
+	if _, err = negotiateFormat(cam, v.width, v.height, v.format.String()); err != nil {
+		cam.Close()
+		return err
+	}
+	if err = cam.SetBufferCount(1); err != nil {
+		cam.Close()
+		return err
+	}
+	if v.streaming {
+		if err = cam.StartStreaming(); err != nil {
+			cam.Close()
+			return err
+		}
+	}
+	v.cam = cam
+	return nil
+}
+
+// largestFrameSize returns the biggest frame the camera can capture in any
+// format we can decode, or zeros if it doesn't say.
+func largestFrameSize(cam *webcam.Webcam) (int, int) {
+	var width, height uint32
+	supported := cam.GetSupportedFormats()
+	for _, code := range v4l2Formats {
+		if _, ok := supported[code]; !ok {
+			continue
+		}
+		for _, fs := range cam.GetSupportedFrameSizes(code) {
+			w, h := fs.MaxWidth, fs.MaxHeight
+			if fs.StepWidth == 0 || fs.StepHeight == 0 {
+				w, h = fs.MinWidth, fs.MinHeight
+			}
+			if w*h > width*height {
+				width, height = w, h
+			}
+		}
+	}
+	return int(width), int(height)
+}
@@ -0,0 +1,163 @@
+package selfies
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Storage is the directory photos and prints are saved in.  Files are written
+// under a temporary name and renamed into place, so a crash or a full disk
+// never leaves a half written photo behind, and are never overwritten.
+type Storage struct {
+	dir     string
+	minFree uint64
+
+	// so two workers can't pick the same name
+	mu sync.Mutex
+	// the last version of each file written with ReplaceFile
+	versions map[string]int
+}
+
+// NewStorage creates dir if needed.  Saving fails if it would leave less
+// than minFree bytes free on the disk.
+func NewStorage(dir string, minFree uint64) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create save directory: %v", err)
+	}
+	return &Storage{dir: dir, minFree: minFree}, nil
+}
+
+// Dir returns the directory files are saved in.
+func (st *Storage) Dir() string {
+	return st.dir
+}
+
+// Path returns where the file called name is saved.
+func (st *Storage) Path(name string) string {
+	return filepath.Join(st.dir, name)
+}
+
+// PhotoName is the name shot number shot (counting from 0) of a session is saved under.
+func PhotoName(session string, shot int) string {
+	return fmt.Sprintf("%s-%d.jpg", session, shot+1)
+}
+
+// Free returns the number of bytes free on the disk.
+func (st *Storage) Free() (uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(st.dir, &fs); err != nil {
+		return 0, err
+	}
+	return fs.Bavail * uint64(fs.Bsize), nil
+}
+
+// CheckFree returns an error if the disk is too full to save to.
+func (st *Storage) CheckFree() error {
+	free, err := st.Free()
+	if err != nil {
+		return fmt.Errorf("checking free space: %v", err)
+	}
+	if free < st.minFree {
+		return fmt.Errorf("disk nearly full, only %d MB free in %s", free>>20, st.dir)
+	}
+	return nil
+}
+
+// SaveJPEG saves img as name, or with a number added to name if that's
+// taken, and returns the path it was saved to.  If meta isn't nil it's
+// written into the file as EXIF.
+func (st *Storage) SaveJPEG(name string, img image.Image, meta *PhotoMeta) (string, error) {
+	return st.WriteFile(name, func(w io.Writer) error {
+		if meta != nil {
+			w = &exifWriter{w: w, segment: exifSegment(meta)}
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 95})
+	})
+}
+
+// WriteFile saves what write writes as name, or with a number added to name
+// if that's taken, and returns the path it was saved to.
+func (st *Storage) WriteFile(name string, write func(io.Writer) error) (string, error) {
+	tmp, err := st.writeTemp(name, write)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	filename := st.unusedPath(name)
+	if err = os.Rename(tmp, filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// ReplaceFile saves data as name, replacing it, unless a later version of it
+// has already been saved.  It's for files that are rewritten as they change,
+// from goroutines that might finish out of order.
+func (st *Storage) ReplaceFile(name string, version int, data []byte) error {
+	tmp, err := st.writeTemp(name, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if v, ok := st.versions[name]; ok && v > version {
+		return nil
+	}
+	if err = os.Rename(tmp, st.Path(name)); err != nil {
+		return err
+	}
+	if st.versions == nil {
+		st.versions = make(map[string]int)
+	}
+	st.versions[name] = version
+	return nil
+}
+
+// writeTemp writes a temporary file in the directory, to be renamed to name.
+func (st *Storage) writeTemp(name string, write func(io.Writer) error) (string, error) {
+	if err := st.CheckFree(); err != nil {
+		return "", err
+	}
+	fp, err := os.CreateTemp(st.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if err = write(fp); err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fp.Name())
+		return "", fmt.Errorf("writing %s: %v", name, err)
+	}
+	return fp.Name(), nil
+}
+
+// unusedPath returns the path for name, adding -2, -3 and so on before its
+// extension until it doesn't name an existing file.
+func (st *Storage) unusedPath(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	filename := st.Path(name)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filename); os.IsNotExist(err) {
+			return filename
+		}
+		filename = st.Path(fmt.Sprintf("%s-%d%s", base, i, ext))
+	}
+}
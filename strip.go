@@ -0,0 +1,28 @@
+package selfies
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// composeStrip lays shots out left to right, top to bottom in the given number
+// of columns on a white background, with margin pixels around and between them.
+// All shots are assumed to be the same size as the first.
+func composeStrip(shots []image.Image, columns, margin int) *image.RGBA {
+	if columns > len(shots) {
+		columns = len(shots)
+	}
+	rows := (len(shots) + columns - 1) / columns
+	shotWidth, shotHeight := shots[0].Bounds().Dx(), shots[0].Bounds().Dy()
+	strip := image.NewRGBA(image.Rect(0, 0,
+		columns*(shotWidth+margin)+margin,
+		rows*(shotHeight+margin)+margin))
+	draw.Draw(strip, strip.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	for i, shot := range shots {
+		x := margin + (i%columns)*(shotWidth+margin)
+		y := margin + (i/columns)*(shotHeight+margin)
+		draw.Draw(strip, image.Rect(x, y, x+shotWidth, y+shotHeight), shot, shot.Bounds().Min, draw.Src)
+	}
+	return strip
+}
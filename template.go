@@ -0,0 +1,270 @@
+package selfies
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" // for decoding template images
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Template describes a print layout.  Positions and sizes are in inches from
+// the top left corner of the paper.
+type Template struct {
+	PaperWidth      float64     `json:"paper_width"`
+	PaperHeight     float64     `json:"paper_height"`
+	DPI             int         `json:"dpi"`
+	Margin          float64     `json:"margin"`
+	BackgroundColor string      `json:"background_color"` // "#rrggbb"
+	Background      string      `json:"background"`       // image scaled to cover the whole page
+	Photos          []Box       `json:"photos"`           // where photos go; if empty, the page is split into Columns
+	Columns         int         `json:"columns"`
+	Footer          float64     `json:"footer"` // kept clear below the grid of photos, for text
+	Logo            *ImageBox   `json:"logo"`
+	Text            []TextBlock `json:"text"`
+
+	background image.Image
+	logo       image.Image
+}
+
+type Box struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+type ImageBox struct {
+	Box
+	File string `json:"file"`
+}
+
+// TextBlock is a line of text centered on X, with its top at Y.
+type TextBlock struct {
+	Text  string  `json:"text"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Size  float64 `json:"size"` // points
+	Color string  `json:"color"`
+}
+
+// LoadTemplate reads a JSON print template.  Image paths in the template are
+// relative to the template file.
+func LoadTemplate(filename string) (*Template, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{PaperWidth: 6, PaperHeight: 4, DPI: 300, BackgroundColor: "#ffffff", Columns: 1}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	dir := filepath.Dir(filename)
+	if t.Background != "" {
+		if t.background, err = loadImage(filepath.Join(dir, t.Background)); err != nil {
+			return nil, fmt.Errorf("%s: background: %v", filename, err)
+		}
+	}
+	if t.Logo != nil {
+		if t.logo, err = loadImage(filepath.Join(dir, t.Logo.File)); err != nil {
+			return nil, fmt.Errorf("%s: logo: %v", filename, err)
+		}
+	}
+	return t, nil
+}
+
+// Validate checks the template for values that can't be rendered.
+func (t *Template) Validate() error {
+	if t.PaperWidth <= 0 || t.PaperHeight <= 0 {
+		return fmt.Errorf("paper size must be positive, got %vx%v", t.PaperWidth, t.PaperHeight)
+	}
+	if t.DPI <= 0 || t.DPI > 1200 {
+		return fmt.Errorf("dpi must be between 1 and 1200, got %d", t.DPI)
+	}
+	if t.Margin < 0 || t.Margin*2 >= t.PaperWidth || t.Margin*2 >= t.PaperHeight {
+		return fmt.Errorf("margin %v doesn't fit on the paper", t.Margin)
+	}
+	if len(t.Photos) == 0 && t.Columns < 1 {
+		return fmt.Errorf("columns must be positive, got %d", t.Columns)
+	}
+	if t.Footer < 0 || t.Margin*2+t.Footer >= t.PaperHeight {
+		return fmt.Errorf("footer %v doesn't fit on the paper", t.Footer)
+	}
+	for i, b := range t.Photos {
+		if b.W <= 0 || b.H <= 0 {
+			return fmt.Errorf("photo %d has no size", i)
+		}
+	}
+	if t.Logo != nil && t.Logo.File == "" {
+		return fmt.Errorf("logo needs a file")
+	}
+	if _, err := parseColor(t.BackgroundColor); err != nil {
+		return err
+	}
+	for _, tb := range t.Text {
+		if tb.Size <= 0 {
+			return fmt.Errorf("text %q needs a size", tb.Text)
+		}
+		if _, err := parseColor(tb.Color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Template) px(inches float64) int {
+	return int(inches*float64(t.DPI) + 0.5)
+}
+
+func (t *Template) rect(b Box) image.Rectangle {
+	return image.Rect(t.px(b.X), t.px(b.Y), t.px(b.X+b.W), t.px(b.Y+b.H))
+}
+
+// photoBoxes returns where n photos go, splitting the page inside the margins
+// and above the footer into a grid if the template doesn't place them itself.
+func (t *Template) photoBoxes(n int) []Box {
+	if len(t.Photos) > 0 {
+		return t.Photos
+	}
+	columns := t.Columns
+	if columns > n {
+		columns = n
+	}
+	rows := (n + columns - 1) / columns
+	w := (t.PaperWidth - t.Margin*float64(columns+1)) / float64(columns)
+	h := (t.PaperHeight - t.Footer - t.Margin*float64(rows+1)) / float64(rows)
+	boxes := make([]Box, n)
+	for i := range boxes {
+		boxes[i] = Box{
+			X: t.Margin + float64(i%columns)*(w+t.Margin),
+			Y: t.Margin + float64(i/columns)*(h+t.Margin),
+			W: w,
+			H: h,
+		}
+	}
+	return boxes
+}
+
+// Render lays photos out on the page.  If the template has more photo spots
+// than there are photos, the photos are repeated.
+func (t *Template) Render(photos []image.Image) (*image.RGBA, error) {
+	if len(photos) == 0 {
+		return nil, fmt.Errorf("nothing to print")
+	}
+	page := image.NewRGBA(image.Rect(0, 0, t.px(t.PaperWidth), t.px(t.PaperHeight)))
+	bg, _ := parseColor(t.BackgroundColor)
+	draw.Draw(page, page.Bounds(), image.NewUniform(bg), image.ZP, draw.Src)
+	if t.background != nil {
+		drawFill(page, page.Bounds(), t.background)
+	}
+	for i, b := range t.photoBoxes(len(photos)) {
+		drawFill(page, t.rect(b), photos[i%len(photos)])
+	}
+	if t.logo != nil {
+		drawFit(page, t.rect(t.Logo.Box), t.logo)
+	}
+	for _, tb := range t.Text {
+		text, err := renderText(tb.Text, int(tb.Size*float64(t.DPI)/72), tb.Color)
+		if err != nil {
+			return nil, err
+		}
+		x := t.px(tb.X) - text.Bounds().Dx()/2
+		y := t.px(tb.Y)
+		draw.Draw(page, text.Bounds().Add(image.Pt(x, y)), text, image.ZP, draw.Over)
+	}
+	return page, nil
+}
+
+// drawFill scales img to cover r, cropping whatever sticks out.
+func drawFill(dst draw.Image, r image.Rectangle, img image.Image) {
+	b := img.Bounds()
+	if b.Dx()*r.Dy() > b.Dy()*r.Dx() {
+		img = resize.Resize(0, uint(r.Dy()), img, resize.Bilinear)
+	} else {
+		img = resize.Resize(uint(r.Dx()), 0, img, resize.Bilinear)
+	}
+	b = img.Bounds()
+	sp := image.Pt(b.Min.X+(b.Dx()-r.Dx())/2, b.Min.Y+(b.Dy()-r.Dy())/2)
+	draw.Draw(dst, r, img, sp, draw.Over)
+}
+
+// drawFit scales img to fit inside r, centered, keeping its aspect ratio.
+func drawFit(dst draw.Image, r image.Rectangle, img image.Image) {
+	b := img.Bounds()
+	if b.Dx()*r.Dy() > b.Dy()*r.Dx() {
+		img = resize.Resize(uint(r.Dx()), 0, img, resize.Bilinear)
+	} else {
+		img = resize.Resize(0, uint(r.Dy()), img, resize.Bilinear)
+	}
+	b = img.Bounds()
+	at := image.Pt(r.Min.X+(r.Dx()-b.Dx())/2, r.Min.Y+(r.Dy()-b.Dy())/2)
+	draw.Draw(dst, b.Sub(b.Min).Add(at), img, b.Min, draw.Over)
+}
+
+// renderText draws text with the embedded font into a transparent image.
+func renderText(text string, size int, hexColor string) (*image.NRGBA, error) {
+	c, err := parseColor(hexColor)
+	if err != nil {
+		return nil, err
+	}
+	font, err := makeFont(size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %v", err)
+	}
+	defer closeFont(font)
+	surf, err := renderBlended(font, text, sdl.Color(c))
+	if err != nil {
+		return nil, fmt.Errorf("failed to render text: %v", err)
+	}
+	defer surf.Free()
+	return surfaceToImage(surf), nil
+}
+
+// surfaceToImage copies an ARGB8888 surface, like the ones the ttf renderer returns, into an image.
+func surfaceToImage(surf *sdl.Surface) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, int(surf.W), int(surf.H)))
+	pix := surf.Pixels()
+	for y := 0; y < int(surf.H); y++ {
+		for x := 0; x < int(surf.W); x++ {
+			i := y*int(surf.Pitch) + x*4
+			o := img.PixOffset(x, y)
+			img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = pix[i+2], pix[i+1], pix[i], pix[i+3]
+		}
+	}
+	return img
+}
+
+func loadImage(filename string) (image.Image, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	img, _, err := image.Decode(fp)
+	return img, err
+}
+
+// parseColor parses a "#rrggbb" color.  An empty string is black.
+func parseColor(s string) (color.RGBA, error) {
+	if s == "" {
+		return color.RGBA{0, 0, 0, 255}, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("bad color %q, want #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
@@ -0,0 +1,71 @@
+package selfies
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// textBox is roughly where a line of text lands: a line is about 1.2 times
+// its point size tall, and the embedded font's letters average a bit over
+// half as wide as they're tall.
+func textBox(tb TextBlock) Box {
+	h := tb.Size / 72 * 1.2
+	w := float64(len(tb.Text)) * tb.Size / 72 * 0.6
+	return Box{X: tb.X - w/2, Y: tb.Y, W: w, H: h}
+}
+
+func overlaps(a, b Box) bool {
+	return a.X < b.X+b.W && b.X < a.X+a.W && a.Y < b.Y+b.H && b.Y < a.Y+a.H
+}
+
+func TestShippedTemplatesKeepTextOffPhotos(t *testing.T) {
+	files, err := filepath.Glob("templates/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no templates found: %v", err)
+	}
+	for _, file := range files {
+		tmpl, err := LoadTemplate(file)
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		// a strip takes up to 4 shots by default, and the grid is laid out for however many there are
+		for n := 1; n <= 4; n++ {
+			for i, photo := range tmpl.photoBoxes(n) {
+				for _, tb := range tmpl.Text {
+					if overlaps(photo, textBox(tb)) {
+						t.Errorf("%s: %q is drawn over photo %d of %d", file, tb.Text, i+1, n)
+					}
+				}
+				if photo.X < 0 || photo.Y < 0 || photo.X+photo.W > tmpl.PaperWidth || photo.Y+photo.H > tmpl.PaperHeight {
+					t.Errorf("%s: photo %d of %d is off the page at %v", file, i+1, n, photo)
+				}
+			}
+		}
+	}
+}
+
+func TestPhotoBoxesFooter(t *testing.T) {
+	tmpl := &Template{PaperWidth: 6, PaperHeight: 4, DPI: 100, Margin: 0.2, Columns: 2, Footer: 0.6}
+	if err := tmpl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	boxes := tmpl.photoBoxes(4)
+	if len(boxes) != 4 {
+		t.Fatalf("got %d boxes for 4 photos", len(boxes))
+	}
+	bottom := boxes[3].Y + boxes[3].H
+	if want := tmpl.PaperHeight - tmpl.Footer - tmpl.Margin; bottom < want-1e-9 || bottom > want+1e-9 {
+		t.Errorf("the grid ends at %v, want %v", bottom, want)
+	}
+	if boxes[0].W != boxes[1].W || boxes[0].H != boxes[2].H || boxes[1].X <= boxes[0].X+boxes[0].W {
+		t.Errorf("uneven grid: %v", boxes)
+	}
+
+	for _, footer := range []float64{-1, 3.6, 4} {
+		tmpl.Footer = footer
+		if err := tmpl.Validate(); err == nil {
+			t.Errorf("a footer of %v on 4in paper is valid", footer)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 #define LEN(x) (sizeof(x)/sizeof((x)[0]))
 
-unsigned long buttonDown[7] = {0, 0, 0, 0, 0, 0, 0};
-int buttonPressed[7] = {0, 0, 0, 0, 0, 0, 0};
-const int buttonPin[7] = {2, 3, 4, 5, 6, 7, 8};
+const int buttonPin[10] = {2, 3, 4, 5, 6, 7, 8, A0, A1, A2};
+unsigned long buttonDown[LEN(buttonPin)] = {0};
+int buttonPressed[LEN(buttonPin)] = {0};
 const int relayPin[4] = {9, 10, 11, 12};
 const int ledPin = 13;
 
@@ -0,0 +1,283 @@
+package selfies
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redbo/selfies/convert"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// errStopped ends reading a video that's no longer being played.
+var errStopped = errors.New("stopped")
+
+// videoFrame is a camera frame for the video being recorded, and when it was
+// read, counting from the start of the video.
+type videoFrame struct {
+	data []byte
+	at   time.Duration
+}
+
+// videoRecording is a video message being recorded.  Frames are copied off
+// the render loop to an encoder on a goroutine of its own, which writes them
+// as they come, so only a few are held at a time.  If the encoder falls
+// behind, frames are dropped and the ones before them repeated, so the video
+// keeps time.
+type videoRecording struct {
+	frames        chan videoFrame
+	format        PixelFormat
+	width, height int
+	started       time.Time
+	last          time.Time
+	timer         *sdl.Texture
+	timerSecond   int
+}
+
+// playedFrame is a frame of a video being played back, and how long to show it.
+type playedFrame struct {
+	img   *image.RGBA
+	delay time.Duration
+}
+
+// videoPlayback is a saved video being played in the review area.  It's read
+// and decoded on a goroutine of its own.
+type videoPlayback struct {
+	tex     *sdl.Texture
+	frames  chan playedFrame
+	quit    chan struct{}
+	started time.Time
+	next    time.Duration
+}
+
+// startVideo starts a new session that records a video message.
+func (s *Selfies) startVideo() {
+	s.startSession()
+	v := &videoRecording{
+		frames:      make(chan videoFrame, 2*s.cfg.Video.FPS),
+		format:      s.texFormat,
+		width:       s.texWidth,
+		height:      s.texHeight,
+		started:     time.Now(),
+		timerSecond: -1,
+	}
+	session, taken := s.sessionID, v.started
+	key, filter, overlays := s.key, s.filter, s.overlays
+	fps, w := s.cfg.Video.FPS, s.cfg.Video.Width
+	h := (w * photoAspectH / photoAspectW) &^ 1
+	thumbWidth, thumbHeight := int(s.layout.ThumbWidth), int(s.layout.ThumbHeight)
+	s.pipeline.Go(func() func() {
+		var first *image.RGBA
+		filename, err := s.storage.WriteFile(session+"-video.avi", func(out io.Writer) error {
+			var err error
+			first, err = encodeVideo(out, v, w, h, fps, key, filter, overlayLayer(overlays, w, h))
+			return err
+		})
+		if err != nil {
+			// drop the rest of the frames
+			go func() {
+				for range v.frames {
+				}
+			}()
+			return func() { s.reportError(fmt.Errorf("failed to save video: %v", err)) }
+		}
+		var thumb *image.RGBA
+		if first != nil {
+			if thumb, err = convert.Image(first, first.Bounds(), thumbWidth, thumbHeight); err != nil {
+				log.Printf("failed to make thumbnail: %v", err)
+			}
+		}
+		return func() { s.videoDeveloped(session, filename, taken, thumb) }
+	})
+	s.video = v
+}
+
+// encodeVideo writes the frames sent for v as a w x h AVI at fps frames a
+// second, until the frames channel is closed, and returns the first frame.
+func encodeVideo(out io.Writer, v *videoRecording, w, h, fps int, key *chromaKey, filter *Filter, layer *image.RGBA) (*image.RGBA, error) {
+	avi, err := newAVIWriter(out, w, h, fps)
+	if err != nil {
+		return nil, err
+	}
+	var first *image.RGBA
+	crop := convert.CenterCrop(image.Rect(0, 0, v.width, v.height), photoAspectW, photoAspectH)
+	for f := range v.frames {
+		n := int(f.at * time.Duration(fps) / time.Second)
+		if n < avi.Frames() {
+			continue
+		}
+		for avi.Frames() > 0 && avi.Frames() < n {
+			if err := avi.Repeat(); err != nil {
+				return nil, err
+			}
+		}
+		img, err := convertFrame(f.data, v.format, v.width, v.height, crop, w, h)
+		if err != nil {
+			log.Printf("BAD FRAME: %v", err)
+			continue
+		}
+		if key != nil {
+			key.RGBA(img)
+		}
+		filter.RGBA(img)
+		if layer != nil {
+			draw.Draw(img, img.Bounds(), layer, image.Point{}, draw.Over)
+		}
+		if first == nil {
+			first = img
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		if err := avi.WriteFrame(buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	return first, avi.Close()
+}
+
+// recordVideoFrame hands the current frame to the video being recorded, if
+// it's time for the next one.
+func (s *Selfies) recordVideoFrame() {
+	v := s.video
+	if v.format != s.texFormat || v.width != s.texWidth || v.height != s.texHeight {
+		return
+	}
+	if time.Since(v.last) < time.Second/time.Duration(s.cfg.Video.FPS) {
+		return
+	}
+	v.last = time.Now()
+	select {
+	case v.frames <- videoFrame{data: append([]byte(nil), s.frame...), at: time.Since(v.started)}:
+	default:
+		log.Printf("video encoding is behind, dropping a frame")
+	}
+}
+
+// finishVideo stops recording.  The video is saved once the encoder has
+// written the frames it was sent.
+func (s *Selfies) finishVideo() {
+	if s.video == nil {
+		return
+	}
+	close(s.video.frames)
+	if s.video.timer != nil {
+		s.video.timer.Destroy()
+	}
+	s.video = nil
+}
+
+// videoDeveloped is called back on the render loop when a video has been
+// saved.  It goes in the thumbnail grid, though it can't be printed, and
+// plays back in the review area.
+func (s *Selfies) videoDeveloped(session, filename string, taken time.Time, thumb *image.RGBA) {
+	if thumb != nil {
+		s.pushThumb(thumb, "")
+	}
+	s.lastVideo = filename
+	if session == s.sessionID {
+		s.record.Clips = append(s.record.Clips, ClipRecord{File: filepath.Base(filename), Kind: "video", Taken: taken})
+		s.saveRecord()
+	}
+	s.playVideo(filename)
+}
+
+// drawVideoTimer shows how long the video has been recording, and how long it can go.
+func (s *Selfies) drawVideoTimer() {
+	v, l := s.video, &s.layout
+	elapsed := int(time.Since(v.started) / time.Second)
+	if elapsed != v.timerSecond {
+		limit := int(s.cfg.Timing.Video.Duration / time.Second)
+		tex, err := s.renderText(fmt.Sprintf("REC %d:%02d / %d:%02d", elapsed/60, elapsed%60, limit/60, limit%60),
+			sdl.Color{R: 255, G: 0, B: 0, A: 255})
+		if err != nil {
+			log.Printf("failed to render video timer: %v", err)
+			return
+		}
+		if v.timer != nil {
+			v.timer.Destroy()
+		}
+		v.timer, v.timerSecond = tex, elapsed
+	}
+	_, _, texWidth, texHeight, _ := v.timer.Query()
+	s.renderer.Copy(v.timer, &sdl.Rect{X: 0, Y: 0, W: texWidth, H: texHeight},
+		&sdl.Rect{X: l.Preview.X + (l.Preview.W-texWidth)/2, Y: l.Preview.Y + texHeight/2, W: texWidth, H: texHeight})
+}
+
+// playVideo starts playing a saved video in the review area, instead of
+// anything that was playing.
+func (s *Selfies) playVideo(filename string) {
+	s.stopPlayback()
+	l := s.layout
+	tex, err := s.renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, l.Review.W, l.Review.H)
+	if err != nil {
+		log.Printf("can't play video: %v", err)
+		return
+	}
+	p := &videoPlayback{tex: tex, frames: make(chan playedFrame, 2), quit: make(chan struct{}), started: time.Now()}
+	go func() {
+		defer close(p.frames)
+		fp, err := os.Open(filename)
+		if err != nil {
+			log.Printf("can't play video: %v", err)
+			return
+		}
+		defer fp.Close()
+		err = readAVI(fp, func(data []byte, delay time.Duration) error {
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			scaled, err := convert.Image(img, img.Bounds(), int(l.Review.W), int(l.Review.H))
+			if err != nil {
+				return err
+			}
+			select {
+			case p.frames <- playedFrame{scaled, delay}:
+				return nil
+			case <-p.quit:
+				return errStopped
+			}
+		})
+		if err != nil && err != errStopped {
+			log.Printf("can't play video %s: %v", filename, err)
+		}
+	}()
+	s.videoPlayback = p
+}
+
+// drawVideoPlayback draws the frame of the video being played that's due,
+// skipping any the decoder was too slow for.
+func (s *Selfies) drawVideoPlayback() {
+	p, l := s.videoPlayback, &s.layout
+	var due *image.RGBA
+wait:
+	for p.next <= time.Since(p.started) {
+		select {
+		case f, ok := <-p.frames:
+			if !ok {
+				s.stopPlayback()
+				return
+			}
+			due, p.next = f.img, p.next+f.delay
+		default:
+			// the decoder is behind
+			break wait
+		}
+	}
+	if due != nil {
+		p.tex.Update(&sdl.Rect{X: 0, Y: 0, W: l.Review.W, H: l.Review.H}, due.Pix, due.Stride)
+	}
+	if p.next > 0 {
+		s.renderer.Copy(p.tex, &sdl.Rect{X: 0, Y: 0, W: l.Review.W, H: l.Review.H}, &l.Review)
+	}
+}